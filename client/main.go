@@ -7,10 +7,14 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const serverAddress = "localhost:8080"
+const registrationGrace = 2 * time.Second // Time for a new monitor to reach the server
+const missedHeartbeats = 3                // Heartbeats missed before warning
 
 type RequestFlight struct {
 	ID            int
@@ -19,6 +23,8 @@ type RequestFlight struct {
 	DepartureTime string
 	SeattoBook    int
 	duration      int
+	RequestID     string
+	Options       map[string]string // Optional parameters, sent after the request ID
 }
 
 type Flight struct {
@@ -28,6 +34,16 @@ type Flight struct {
 	DepartureTime    string
 	Airfare          float64
 	SeatAvailability int
+	FareClasses      []FareClass
+}
+
+type FareClass struct {
+	Code             string
+	Name             string
+	Fare             float64
+	SeatAvailability int
+	Refundable       bool
+	EarnRate         float64
 }
 
 func main() {
@@ -45,6 +61,8 @@ func main() {
 	defer conn.Close()
 
 	fmt.Println("Connected to the server at", serverAddress)
+	go listen(conn)
+	go watchServer(conn)
 	for {
 		showMenu()
 		handleUserChoice(conn)
@@ -59,7 +77,22 @@ func showMenu() {
 	fmt.Println("4. Monitor seat availability updates")
 	fmt.Println("5. Query points based on IP address")
 	fmt.Println("6. Make a seat reservation with points")
-	fmt.Println("7. Exit")
+	fmt.Println("7. Search flights with filters")
+	fmt.Println("8. Find airports")
+	fmt.Println("9. Points statement")
+	fmt.Println("10. Points expiring soon")
+	fmt.Println("11. Transfer points")
+	fmt.Println("12. Make a seat reservation with cash and points")
+	fmt.Println("13. Stop monitoring a flight")
+	fmt.Println("14. Extend a flight monitor")
+	fmt.Println("15. List my monitors")
+	fmt.Println("16. Monitor a route")
+	fmt.Println("17. Stop monitoring a route")
+	fmt.Println("18. Ping the server")
+	fmt.Println("19. Register a webhook (operator)")
+	fmt.Println("20. Replay webhook deliveries (operator)")
+	fmt.Println("21. Generate a seat map (operator)")
+	fmt.Println("0. Exit")
 	fmt.Print("Enter your choice: ")
 }
 
@@ -80,6 +113,36 @@ func handleUserChoice(conn *net.UDPConn) {
 	case 6:
 		makeSeatReservationWithPoints(conn)
 	case 7:
+		searchFlights(conn)
+	case 8:
+		findAirports(conn)
+	case 9:
+		pointsStatement(conn)
+	case 10:
+		expiringPoints(conn)
+	case 11:
+		transferPoints(conn)
+	case 12:
+		makeSeatReservationWithCashAndPoints(conn)
+	case 13:
+		cancelMonitor(conn)
+	case 14:
+		renewMonitor(conn)
+	case 15:
+		listMonitors(conn)
+	case 16:
+		monitorRoute(conn)
+	case 17:
+		cancelRouteMonitor(conn)
+	case 18:
+		ping(conn)
+	case 19:
+		registerWebhook(conn)
+	case 20:
+		replayWebhooks(conn)
+	case 21:
+		generateSeatMap(conn)
+	case 0:
 		fmt.Println("Exiting...")
 		os.Exit(0)
 	default:
@@ -94,7 +157,78 @@ func queryFlights(conn *net.UDPConn) {
 	fmt.Print("Enter destination: ")
 	fmt.Scan(&destination)
 
-	request, _ := EncodeClientRequest(RequestFlight{Source: source, Destination: destination}, 1)
+	options := map[string]string{}
+	for {
+		request, _ := EncodeClientRequest(RequestFlight{Source: source, Destination: destination, Options: options}, 1)
+		conn.Write(request)
+
+		attrs := receiveResponse(conn)
+		if attrs["next_cursor"] == "" {
+			return
+		}
+		var input string
+		fmt.Print("Show more flights? (y/n): ")
+		fmt.Scan(&input)
+		if input != "y" {
+			return
+		}
+		options["cursor"] = attrs["next_cursor"]
+	}
+}
+
+func searchFlights(conn *net.UDPConn) {
+	var source, destination, input string
+	fmt.Print("Enter source: ")
+	fmt.Scan(&source)
+	fmt.Print("Enter destination: ")
+	fmt.Scan(&destination)
+
+	options := map[string]string{}
+	prompts := []struct{ key, label string }{
+		{"min_price", "minimum price"},
+		{"max_price", "maximum price"},
+		{"min_seats", "minimum available seats"},
+		{"depart_after", "earliest departure (YYYY-MM-DD)"},
+		{"depart_before", "latest departure (YYYY-MM-DD)"},
+		{"carrier", "carrier"},
+		{"max_stops", "maximum stops"},
+		{"sort", "sort order (price, departure, duration)"},
+	}
+	for _, prompt := range prompts {
+		fmt.Printf("Enter %s (- to skip): ", prompt.label)
+		fmt.Scan(&input)
+		if input == "-" {
+			continue
+		}
+		if prompt.key == "depart_before" && len(input) == len("2006-01-02") {
+			input += " 23:59" // Include the whole day
+		}
+		options[prompt.key] = input
+	}
+
+	for {
+		request, _ := EncodeClientRequest(RequestFlight{Source: source, Destination: destination, Options: options}, 9)
+		conn.Write(request)
+
+		attrs := receiveResponse(conn)
+		if attrs["next_cursor"] == "" {
+			return
+		}
+		fmt.Print("Show next page? (y/n): ")
+		fmt.Scan(&input)
+		if input != "y" {
+			return
+		}
+		options["cursor"] = attrs["next_cursor"]
+	}
+}
+
+func findAirports(conn *net.UDPConn) {
+	var text string
+	fmt.Print("Enter airport code, city or name: ")
+	fmt.Scan(&text)
+
+	request, _ := EncodeClientRequest(RequestFlight{Source: text}, 10)
 	conn.Write(request)
 
 	receiveResponse(conn)
@@ -118,7 +252,9 @@ func makeSeatReservation(conn *net.UDPConn) {
 	fmt.Print("Enter number of seats to reserve: ")
 	fmt.Scan(&seats)
 
-	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, SeattoBook: seats}, 3)
+	options := fareClassOption()
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, SeattoBook: seats, Options: options}, 3)
 	conn.Write(request)
 
 	receiveResponse(conn)
@@ -131,12 +267,46 @@ func makeSeatReservationWithPoints(conn *net.UDPConn) {
 	fmt.Print("Enter number of seats to reserve: ")
 	fmt.Scan(&seats)
 
-	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, SeattoBook: seats}, 6)
+	options := fareClassOption()
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, SeattoBook: seats, Options: options}, 6)
+	conn.Write(request)
+
+	receiveResponse(conn)
+}
+
+// fareClassOption asks for an optional fare class to book from.
+func makeSeatReservationWithCashAndPoints(conn *net.UDPConn) {
+	var flightID, seats int
+	var points string
+	fmt.Print("Enter flight ID: ")
+	fmt.Scan(&flightID)
+	fmt.Print("Enter number of seats to reserve: ")
+	fmt.Scan(&seats)
+	fmt.Print("Enter amount of the price to pay with points: ")
+	fmt.Scan(&points)
+
+	options := map[string]string{"points": points}
+	for key, value := range fareClassOption() {
+		options[key] = value
+	}
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, SeattoBook: seats, Options: options}, 21)
 	conn.Write(request)
 
 	receiveResponse(conn)
 }
 
+func fareClassOption() map[string]string {
+	var fareClass string
+	fmt.Print("Enter fare class (- for any): ")
+	fmt.Scan(&fareClass)
+	if fareClass == "-" {
+		return nil
+	}
+	return map[string]string{"fare_class": fareClass}
+}
+
 func monitorSeatAvailability(conn *net.UDPConn) {
 	var flightID, duration int
 	fmt.Print("Enter flight ID to monitor: ")
@@ -144,45 +314,583 @@ func monitorSeatAvailability(conn *net.UDPConn) {
 	fmt.Print("Enter monitor duration (in seconds): ")
 	fmt.Scan(&duration)
 
-	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, duration: duration}, 4)
+	var input string
+	options := map[string]string{"notify_end": "1"}
+	prompts := []struct{ key, label string }{
+		{"seats_below", "notify only while seats are below"},
+		{"seats_available", "notify only when seats become available again (1)"},
+		{"fare_threshold", "notify only when the fare crosses"},
+		{"fare_class", "fare class to watch"},
+	}
+	for _, prompt := range prompts {
+		fmt.Printf("Enter %s (- to skip): ", prompt.label)
+		fmt.Scan(&input)
+		if input != "-" {
+			options[prompt.key] = input
+		}
+	}
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, duration: duration, Options: options}, 4)
 	conn.Write(request)
 
-	monitorEndTime := time.Now().Add(time.Duration(duration) * time.Second)
-	for time.Now().Before(monitorEndTime) {
-		receiveResponse(conn)
+	monitoring.Lock()
+	monitoring.flights[flightID] = time.Now().Add(time.Duration(duration) * time.Second)
+	monitoring.added[fmt.Sprintf("flight:%d", flightID)] = time.Now()
+	monitoring.Unlock()
+	fmt.Printf("Monitoring flight %d for %d seconds; updates are shown as they arrive\n", flightID, duration)
+}
+
+func cancelMonitor(conn *net.UDPConn) {
+	var flightID int
+	fmt.Print("Enter flight ID to stop monitoring: ")
+	fmt.Scan(&flightID)
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID}, 22)
+	conn.Write(request)
+
+	receiveResponse(conn)
+	// Stop listening for the flight even if the server had already forgotten it
+	monitoring.Lock()
+	delete(monitoring.flights, flightID)
+	monitoring.Unlock()
+}
+
+func renewMonitor(conn *net.UDPConn) {
+	var flightID, duration int
+	fmt.Print("Enter flight ID to keep monitoring: ")
+	fmt.Scan(&flightID)
+	fmt.Print("Enter new monitor duration (in seconds): ")
+	fmt.Scan(&duration)
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, duration: duration}, 23)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if expiresAt, err := time.Parse(time.RFC3339, attrs["expires_at"]); err == nil {
+		monitoring.Lock()
+		monitoring.flights[flightID] = expiresAt
+		monitoring.Unlock()
 	}
 }
 
-func queryPoints(conn *net.UDPConn) {
-	request, _ := EncodeClientRequest(RequestFlight{}, 5)
+func listMonitors(conn *net.UDPConn) {
+	request, _ := EncodeClientRequest(RequestFlight{}, 24)
+	conn.Write(request)
+
+	receiveResponse(conn)
+}
+
+func monitorRoute(conn *net.UDPConn) {
+	var source, destination, input string
+	var duration int
+	fmt.Print("Enter source: ")
+	fmt.Scan(&source)
+	fmt.Print("Enter destination: ")
+	fmt.Scan(&destination)
+	fmt.Print("Enter monitor duration (in seconds): ")
+	fmt.Scan(&duration)
+
+	options := map[string]string{"notify_end": "1"}
+	prompts := []struct{ key, label string }{
+		{"depart_after", "earliest departure (YYYY-MM-DD)"},
+		{"depart_before", "latest departure (YYYY-MM-DD)"},
+	}
+	for _, prompt := range prompts {
+		fmt.Printf("Enter %s (- to skip): ", prompt.label)
+		fmt.Scan(&input)
+		if input == "-" {
+			continue
+		}
+		if prompt.key == "depart_before" && len(input) == len("2006-01-02") {
+			input += " 23:59" // Include the whole day
+		}
+		options[prompt.key] = input
+	}
+	request, _ := EncodeClientRequest(RequestFlight{Source: source, Destination: destination, duration: duration, Options: options}, 27)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if expiresAt, err := time.Parse(time.RFC3339, attrs["expires_at"]); err == nil {
+		monitoring.Lock()
+		monitoring.routes[routeKey(source, destination)] = expiresAt
+		monitoring.added["route:"+routeKey(source, destination)] = time.Now()
+		monitoring.Unlock()
+	}
+}
+
+func cancelRouteMonitor(conn *net.UDPConn) {
+	var source, destination string
+	fmt.Print("Enter source: ")
+	fmt.Scan(&source)
+	fmt.Print("Enter destination: ")
+	fmt.Scan(&destination)
+
+	request, _ := EncodeClientRequest(RequestFlight{Source: source, Destination: destination}, 22)
+	conn.Write(request)
+
+	receiveResponse(conn)
+	monitoring.Lock()
+	delete(monitoring.routes, routeKey(source, destination))
+	monitoring.Unlock()
+}
+
+func routeKey(source, destination string) string {
+	return strings.ToLower(strings.TrimSpace(source)) + "|" + strings.ToLower(strings.TrimSpace(destination))
+}
+
+func ping(conn *net.UDPConn) {
+	request, _ := EncodeClientRequest(RequestFlight{}, 28)
+	conn.Write(request) // The reply is printed by listen
+}
+
+func registerWebhook(conn *net.UDPConn) {
+	var endpointURL, eventType string
+	fmt.Print("Enter endpoint URL: ")
+	fmt.Scan(&endpointURL)
+	fmt.Print("Enter event type (flight.changed, booking.created, booking.cancelled, points.changed or * for all): ")
+	fmt.Scan(&eventType)
+
+	options := map[string]string{"url": endpointURL, "event_type": eventType}
+	request, _ := EncodeClientRequest(RequestFlight{Options: options}, 29)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if attrs["secret"] != "" {
+		fmt.Println("Signing secret (keep it safe):", attrs["secret"])
+	}
+}
+
+func generateSeatMap(conn *net.UDPConn) {
+	var flightID int
+	var rows, letters, cabins, exitRows string
+	fmt.Print("Enter flight ID: ")
+	fmt.Scan(&flightID)
+	fmt.Print("Enter number of rows: ")
+	fmt.Scan(&rows)
+	fmt.Print("Enter seat letters, _ for each aisle (e.g. ABC_DEF): ")
+	fmt.Scan(&letters)
+	fmt.Print("Enter cabin rows (e.g. J:1-3,Y:4-30, - if the flight has no fare classes): ")
+	fmt.Scan(&cabins)
+	fmt.Print("Enter exit rows (e.g. 12,13, - for none): ")
+	fmt.Scan(&exitRows)
+
+	options := map[string]string{"layout_rows": rows, "layout_letters": strings.ReplaceAll(letters, "_", " ")}
+	if cabins != "-" {
+		options["layout_cabins"] = cabins
+	}
+	if exitRows != "-" {
+		options["exit_rows"] = exitRows
+	}
+	request, _ := EncodeClientRequest(RequestFlight{ID: flightID, Options: options}, 32)
+	conn.Write(request)
+
+	receiveResponse(conn)
+}
+
+func replayWebhooks(conn *net.UDPConn) {
+	var deliveryID int
+	fmt.Print("Enter delivery ID to replay (0 for every dead delivery): ")
+	fmt.Scan(&deliveryID)
+
+	request, _ := EncodeClientRequest(RequestFlight{ID: deliveryID}, 30)
 	conn.Write(request)
 
 	receiveResponse(conn)
 }
 
-func receiveResponse(conn *net.UDPConn) {
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+// monitoring holds when each monitored flight's and route's updates stop
+// being shown, the last update sequence number seen for each, and when each
+// was registered.
+var monitoring = struct {
+	sync.Mutex
+	flights        map[int]time.Time
+	sequences      map[int]uint64
+	routes         map[string]time.Time
+	routeSequences map[string]uint64
+	added          map[string]time.Time
+}{flights: map[int]time.Time{}, sequences: map[int]uint64{}, routes: map[string]time.Time{}, routeSequences: map[string]uint64{}, added: map[string]time.Time{}}
+
+// server holds what the client last heard from the server: its epoch, which
+// changes when it restarts, and when its last datagram arrived.
+var server = struct {
+	sync.Mutex
+	epoch     string
+	lastHeard time.Time
+	interval  time.Duration // Heartbeat interval; zero until a heartbeat arrives
+	silent    bool
+}{}
+
+// replies carries every datagram that is not a monitor callback to the
+// request waiting for it.
+var replies = make(chan []byte, 16)
+
+// listen reads every datagram from the server. Monitor callbacks (opcode 4)
+// are printed as they arrive, unless they are for a flight the client has
+// stopped monitoring; everything else is handed to receiveResponse.
+func listen(conn *net.UDPConn) {
+	for {
+		buffer := make([]byte, 1024)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			fmt.Println("Error reading from server:", err)
+			return
+		}
+		if n >= 2 && buffer[1] == 28 {
+			_, _, _, message, attrs, err := decodeServerResponse(buffer[:n])
+			if err == nil {
+				heard(attrs)
+				reconcile(attrs)
+				fmt.Printf("\n[Ping] %s, server epoch %s\n", message, attrs["epoch"])
+			}
+			continue
+		}
+		if n < 2 || buffer[1] != 4 {
+			heard(nil)
+			replies <- buffer[:n]
+			continue
+		}
+		_, _, flights, message, attrs, err := decodeServerResponse(buffer[:n])
+		if err != nil {
+			continue
+		}
+		heard(attrs)
+		if attrs["heartbeat"] == "1" {
+			reconcile(attrs)
+			continue
+		}
+		if attrs["source"] != "" {
+			key := routeKey(attrs["source"], attrs["destination"])
+			monitoring.Lock()
+			until, ok := monitoring.routes[key]
+			if attrs["ended"] == "1" {
+				delete(monitoring.routes, key)
+			}
+			monitoring.Unlock()
+			if !ok || (time.Now().After(until) && attrs["ended"] != "1") {
+				continue
+			}
+			fmt.Println("\n[Update]", message)
+			if attrs["seq"] != "" {
+				showRouteUpdate(conn, flights, attrs)
+			}
+			continue
+		}
+		if flightID, err := strconv.Atoi(attrs["flight_id"]); err == nil {
+			monitoring.Lock()
+			until, ok := monitoring.flights[flightID]
+			if attrs["ended"] == "1" {
+				// The server has dropped the registration
+				delete(monitoring.flights, flightID)
+			}
+			monitoring.Unlock()
+			if !ok || (time.Now().After(until) && attrs["ended"] != "1") {
+				continue
+			}
+		}
+		fmt.Println("\n[Update]", message)
+		if attrs["seq"] != "" {
+			showFlightUpdate(conn, flights, attrs)
+		}
+	}
+}
+
+// showFlightUpdate acknowledges and prints a structured monitor callback.
+// When updates for the flight were missed it asks the server to resend the
+// current state.
+func showFlightUpdate(conn *net.UDPConn, flights []Flight, attrs map[string]string) {
+	flightID, _ := strconv.Atoi(attrs["flight_id"])
+	seq, err := strconv.ParseUint(attrs["seq"], 10, 64)
+	if err != nil {
+		return
+	}
+	// Acknowledge even duplicates, in case the earlier acknowledgement was lost
+	ack, _ := EncodeClientRequest(RequestFlight{ID: flightID, Options: map[string]string{"seq": attrs["seq"]}}, 25)
+	conn.Write(ack)
+
+	resync := attrs["change"] == "resync"
+	monitoring.Lock()
+	last, seen := monitoring.sequences[flightID]
+	if seq > last || resync {
+		monitoring.sequences[flightID] = seq
+	}
+	monitoring.Unlock()
+	if seen && seq <= last && !resync {
+		return // Duplicate or out of date
+	}
+	if seen && seq > last+1 && !resync {
+		fmt.Printf("Missed %d update(s) for flight %d, requesting current state\n", seq-last-1, flightID)
+		request, _ := EncodeClientRequest(RequestFlight{ID: flightID}, 26)
+		conn.Write(request)
+	}
+	fmt.Printf("Change: %s, Sequence: %d, Time: %s\n", attrs["change"], seq, attrs["timestamp"])
+	for _, flight := range flights {
+		fmt.Printf("Flight ID: %d, Source: %s, Destination: %s, Departure Time: %s, Airfare: %.2f, Seats Available: %d\n",
+			flight.ID, flight.Source, flight.Destination, flight.DepartureTime, flight.Airfare, flight.SeatAvailability)
+	}
+}
+
+// heard records a datagram from the server. A new epoch means the server
+// restarted and its sequence numbers started over.
+func heard(attrs map[string]string) {
+	server.Lock()
+	server.lastHeard = time.Now()
+	resumed := server.silent
+	server.silent = false
+	if seconds, err := strconv.Atoi(attrs["interval"]); err == nil {
+		server.interval = time.Duration(seconds) * time.Second
+	}
+	epoch := attrs["epoch"]
+	restarted := epoch != "" && server.epoch != "" && epoch != server.epoch
+	if epoch != "" {
+		server.epoch = epoch
+	}
+	server.Unlock()
+
+	if resumed {
+		fmt.Println("\nThe server is responding again")
+	}
+	if restarted {
+		fmt.Println("\nThe server restarted; update sequence numbers start over")
+		monitoring.Lock()
+		monitoring.sequences = map[int]uint64{}
+		monitoring.routeSequences = map[string]uint64{}
+		monitoring.Unlock()
+	}
+}
+
+// reconcile compares the monitors listed in a heartbeat or ping reply with
+// the local ones, and drops those the server no longer has so the user
+// knows to register them again.
+func reconcile(attrs map[string]string) {
+	if attrs["partial"] == "1" {
+		return // The server could not list every monitor
+	}
+	flights := map[string]bool{}
+	for _, id := range listAttribute(attrs, "flights") {
+		flights[id] = true
+	}
+	routes := map[string]bool{}
+	for _, route := range listAttribute(attrs, "routes") {
+		if parts := strings.SplitN(route, "|", 2); len(parts) == 2 {
+			routes[routeKey(parts[0], parts[1])] = true
+		}
+	}
+
+	now := time.Now()
+	var lost []string
+	monitoring.Lock()
+	for flightID, until := range monitoring.flights {
+		subject := fmt.Sprintf("flight:%d", flightID)
+		if now.After(until) || flights[strconv.Itoa(flightID)] || now.Sub(monitoring.added[subject]) < registrationGrace {
+			continue
+		}
+		delete(monitoring.flights, flightID)
+		lost = append(lost, fmt.Sprintf("flight %d", flightID))
+	}
+	for key, until := range monitoring.routes {
+		if now.After(until) || routes[key] || now.Sub(monitoring.added["route:"+key]) < registrationGrace {
+			continue
+		}
+		delete(monitoring.routes, key)
+		lost = append(lost, strings.Replace(key, "|", " -> ", 1))
+	}
+	monitoring.Unlock()
+	for _, subject := range lost {
+		fmt.Printf("\nThe server is no longer monitoring %s; register again to keep receiving updates\n", subject)
+	}
+}
+
+// listAttribute joins a comma-separated list the server split across key,
+// key.2, key.3 and so on.
+func listAttribute(attrs map[string]string, key string) []string {
+	var items []string
+	for part := 1; ; part++ {
+		name := key
+		if part > 1 {
+			name = key + "." + strconv.Itoa(part)
+		}
+		value, ok := attrs[name]
+		if !ok {
+			return items
+		}
+		if value != "" {
+			items = append(items, strings.Split(value, ",")...)
+		}
+	}
+}
+
+// watchServer warns when a monitoring client stops hearing heartbeats, and
+// pings the server once per heartbeat interval until it answers.
+func watchServer(conn *net.UDPConn) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastPing time.Time
+	for range ticker.C {
+		now := time.Now()
+		server.Lock()
+		if !monitoringAny(now) {
+			server.interval = 0 // Heartbeats stop when nothing is monitored
+		}
+		quiet := now.Sub(server.lastHeard)
+		overdue := server.interval > 0 && quiet > missedHeartbeats*server.interval
+		warn := overdue && !server.silent
+		if overdue {
+			server.silent = true
+		}
+		interval := server.interval
+		server.Unlock()
+
+		if warn {
+			fmt.Printf("\nNo heartbeat from the server for %s; it may be down\n", quiet.Round(time.Second))
+		}
+		if overdue && now.Sub(lastPing) >= interval {
+			lastPing = now
+			ping(conn)
+		}
+	}
+}
+
+func monitoringAny(now time.Time) bool {
+	monitoring.Lock()
+	defer monitoring.Unlock()
+	for _, until := range monitoring.flights {
+		if now.Before(until) {
+			return true
+		}
+	}
+	for _, until := range monitoring.routes {
+		if now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// showRouteUpdate acknowledges and prints a route monitor callback. Routes
+// have no single state to resend, so missed updates are only reported.
+func showRouteUpdate(conn *net.UDPConn, flights []Flight, attrs map[string]string) {
+	seq, err := strconv.ParseUint(attrs["seq"], 10, 64)
 	if err != nil {
-		fmt.Println("Error reading from server:", err)
 		return
 	}
+	ack, _ := EncodeClientRequest(RequestFlight{Source: attrs["source"], Destination: attrs["destination"], Options: map[string]string{"seq": attrs["seq"]}}, 25)
+	conn.Write(ack)
+
+	key := routeKey(attrs["source"], attrs["destination"])
+	monitoring.Lock()
+	last, seen := monitoring.routeSequences[key]
+	if seq > last {
+		monitoring.routeSequences[key] = seq
+	}
+	monitoring.Unlock()
+	if seen && seq <= last {
+		return // Duplicate or out of date
+	}
+	if seen && seq > last+1 {
+		fmt.Printf("Missed %d update(s) for %s -> %s\n", seq-last-1, attrs["source"], attrs["destination"])
+	}
+	fmt.Printf("Change: %s, Sequence: %d, Time: %s\n", attrs["change"], seq, attrs["timestamp"])
+	for _, flight := range flights {
+		fmt.Printf("Flight ID: %d, Source: %s, Destination: %s, Departure Time: %s, Airfare: %.2f, Seats Available: %d\n",
+			flight.ID, flight.Source, flight.Destination, flight.DepartureTime, flight.Airfare, flight.SeatAvailability)
+	}
+}
+
+func queryPoints(conn *net.UDPConn) {
+	request, _ := EncodeClientRequest(RequestFlight{}, 5)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if attrs["tier"] != "" {
+		fmt.Printf("Tier: %s (%s points, %s flights this year)\n", attrs["tier"], attrs["tier_points"], attrs["tier_segments"])
+	}
+	if attrs["next_tier"] != "" {
+		fmt.Printf("Next tier: %s at %s points or %s flights\n", attrs["next_tier"], attrs["next_tier_points"], attrs["next_tier_segments"])
+	}
+}
+
+func pointsStatement(conn *net.UDPConn) {
+	var input string
+	options := map[string]string{}
+	for {
+		request, _ := EncodeClientRequest(RequestFlight{Options: options}, 18)
+		conn.Write(request)
+
+		attrs := receiveResponse(conn)
+		if attrs["balance"] != "" {
+			fmt.Printf("Balance: %s (ledger: %s)\n", attrs["balance"], attrs["ledger_balance"])
+		}
+		if attrs["next_cursor"] == "" {
+			return
+		}
+		fmt.Print("Show older entries? (y/n): ")
+		fmt.Scan(&input)
+		if input != "y" {
+			return
+		}
+		options["cursor"] = attrs["next_cursor"]
+	}
+}
+
+func expiringPoints(conn *net.UDPConn) {
+	var days int
+	fmt.Print("Enter number of days to look ahead: ")
+	fmt.Scan(&days)
+
+	request, _ := EncodeClientRequest(RequestFlight{duration: days * 24 * 60 * 60}, 19)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if attrs["shown"] != "" {
+		fmt.Printf("Showing the %s soonest lots; the total covers them all\n", attrs["shown"])
+	}
+}
+
+func transferPoints(conn *net.UDPConn) {
+	var recipient, points string
+	fmt.Print("Enter recipient address (ip:port): ")
+	fmt.Scan(&recipient)
+	fmt.Print("Enter points to transfer: ")
+	fmt.Scan(&points)
+
+	// The same key is sent on every retry so the points move only once
+	options := map[string]string{
+		"recipient":       recipient,
+		"points":          points,
+		"idempotency_key": strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	request, _ := EncodeClientRequest(RequestFlight{Options: options}, 20)
+	conn.Write(request)
+
+	attrs := receiveResponse(conn)
+	if attrs["balance"] != "" {
+		fmt.Println("Remaining balance:", attrs["balance"])
+	}
+}
 
-	statuscode, opcode, flights, message, err := decodeServerResponse(buffer[:n])
+// receiveResponse prints one server reply and returns the attributes that
+// followed its message, if any.
+func receiveResponse(conn *net.UDPConn) map[string]string {
+	data := <-replies
+	statuscode, opcode, flights, message, attrs, err := decodeServerResponse(data)
 	if err != nil {
 		fmt.Println("Error decoding response:", err)
-		return
+		return nil
 	}
 
 	fmt.Printf("Status Code: %d, Opcode: %d\n", statuscode, opcode)
 	for _, flight := range flights {
 		fmt.Printf("Flight ID: %d, Source: %s, Destination: %s, Departure Time: %s, Airfare: %.2f, Seats Available: %d\n",
 			flight.ID, flight.Source, flight.Destination, flight.DepartureTime, flight.Airfare, flight.SeatAvailability)
+		for _, class := range flight.FareClasses {
+			fmt.Printf("    Class %s (%s): Fare: %.2f, Seats Available: %d, Refundable: %t, Earn Rate: %.2f\n",
+				class.Code, class.Name, class.Fare, class.SeatAvailability, class.Refundable, class.EarnRate)
+		}
 	}
 	fmt.Println("Message:", message)
+	return attrs
 }
 
-func decodeServerResponse(data []byte) (statuscode, opcode int, flights []Flight, message string, err error) {
+func decodeServerResponse(data []byte) (statuscode, opcode int, flights []Flight, message string, attrs map[string]string, err error) {
 	buffer := bytes.NewBuffer(data)
 
 	// Read the statuscode and opcode
@@ -197,8 +905,45 @@ func decodeServerResponse(data []byte) (statuscode, opcode int, flights []Flight
 		return
 	}
 
-	// Read flights
+	// Read flights. Itinerary replies (opcode 7) carry legs and totals instead.
 	for i := 0; i < int(flightCount); i++ {
+		if opcode == 10 {
+			err = decodeAirport(buffer)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if opcode == 24 {
+			err = decodeMonitor(buffer)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if opcode == 19 {
+			err = decodePointsLot(buffer)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if opcode == 18 {
+			err = decodeLedgerEntry(buffer)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if opcode == 7 {
+			var legs []Flight
+			legs, err = decodeItinerary(buffer)
+			if err != nil {
+				return
+			}
+			flights = append(flights, legs...)
+			continue
+		}
 		var flight Flight
 		flight, err = decodeFlight(buffer)
 		if err != nil {
@@ -209,9 +954,121 @@ func decodeServerResponse(data []byte) (statuscode, opcode int, flights []Flight
 
 	// Read the message
 	message, err = readString(buffer)
+	if err != nil {
+		return
+	}
+
+	// Read the optional attributes that follow the message
+	attrs, err = readAttributes(buffer)
 	return
 }
 
+func readAttributes(buffer *bytes.Buffer) (map[string]string, error) {
+	attrs := map[string]string{}
+	count, err := buffer.ReadByte()
+	if err != nil {
+		// No attributes were sent
+		return attrs, nil
+	}
+	for i := 0; i < int(count); i++ {
+		key, err := readString(buffer)
+		if err != nil {
+			return attrs, err
+		}
+		value, err := readString(buffer)
+		if err != nil {
+			return attrs, err
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
+
+// decodeAirport reads and prints one airport match.
+func decodeAirport(buffer *bytes.Buffer) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		field, err := readString(buffer)
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	fmt.Printf("Airport: %s - %s, %s, %s (%s)\n", fields[0], fields[1], fields[2], fields[3], fields[4])
+	return nil
+}
+
+// decodeLedgerEntry reads and prints one points statement line.
+func decodeLedgerEntry(buffer *bytes.Buffer) error {
+	fields := make([]string, 7)
+	for i := range fields {
+		field, err := readString(buffer)
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	fmt.Printf("#%s %s %s: %s (balance %s, booking %s) %s\n", fields[0], fields[5], fields[1], fields[2], fields[3], fields[4], fields[6])
+	return nil
+}
+
+// decodeMonitor reads and prints one monitor registration.
+func decodeMonitor(buffer *bytes.Buffer) error {
+	subject, err := readString(buffer)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := readString(buffer)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.Atoi(subject); err == nil {
+		subject = "flight " + subject
+	}
+	fmt.Printf("Monitoring %s until %s\n", subject, expiresAt)
+	return nil
+}
+
+// decodePointsLot reads and prints one batch of points due to expire.
+func decodePointsLot(buffer *bytes.Buffer) error {
+	fields := make([]string, 3)
+	for i := range fields {
+		field, err := readString(buffer)
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	fmt.Printf("%s points earned %s expire %s\n", fields[0], fields[1], fields[2])
+	return nil
+}
+
+// decodeItinerary reads one itinerary, prints its totals and returns its legs.
+func decodeItinerary(buffer *bytes.Buffer) ([]Flight, error) {
+	legCount, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var legs []Flight
+	for i := 0; i < int(legCount); i++ {
+		leg, err := decodeFlight(buffer)
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, leg)
+	}
+	totalFare, err := readString(buffer)
+	if err != nil {
+		return nil, err
+	}
+	totalMinutes, err := readString(buffer)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Itinerary with %d leg(s), Total Airfare: %s, Total Time: %s min\n", len(legs), totalFare, totalMinutes)
+	return legs, nil
+}
+
 func decodeFlight(buffer *bytes.Buffer) (Flight, error) {
 	var flight Flight
 	// Decode each field of Flight
@@ -248,6 +1105,27 @@ func decodeFlight(buffer *bytes.Buffer) (Flight, error) {
 	}
 	flight.SeatAvailability, _ = strconv.Atoi(seatsStr)
 
+	classCount, err := buffer.ReadByte()
+	if err != nil {
+		return flight, err
+	}
+	for i := 0; i < int(classCount); i++ {
+		fields := make([]string, 6)
+		for j := range fields {
+			if fields[j], err = readString(buffer); err != nil {
+				return flight, err
+			}
+		}
+		var class FareClass
+		class.Code = fields[0]
+		class.Name = fields[1]
+		class.Fare, _ = strconv.ParseFloat(fields[2], 64)
+		class.SeatAvailability, _ = strconv.Atoi(fields[3])
+		class.Refundable = fields[4] == "1"
+		class.EarnRate, _ = strconv.ParseFloat(fields[5], 64)
+		flight.FareClasses = append(flight.FareClasses, class)
+	}
+
 	return flight, nil
 }
 
@@ -317,5 +1195,23 @@ func encodeFlight(buffer *bytes.Buffer, flight RequestFlight) error {
 	if err := encodeString(buffer, durationStr); err != nil {
 		return err
 	}
+	if err := encodeString(buffer, flight.RequestID); err != nil {
+		return err
+	}
+	return encodeOptions(buffer, flight.Options)
+}
+
+func encodeOptions(buffer *bytes.Buffer, options map[string]string) error {
+	if err := buffer.WriteByte(byte(len(options))); err != nil {
+		return err
+	}
+	for key, value := range options {
+		if err := encodeString(buffer, key); err != nil {
+			return err
+		}
+		if err := encodeString(buffer, value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
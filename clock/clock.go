@@ -0,0 +1,49 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Background jobs take one so they can be driven by a
+// Fixed clock instead of the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real reads the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Fixed reports a time that only changes when set or advanced.
+type Fixed struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFixed(now time.Time) *Fixed {
+	return &Fixed{now: now}
+}
+
+func (f *Fixed) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the clock to now.
+func (f *Fixed) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the clock forward by d.
+func (f *Fixed) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
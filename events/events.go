@@ -0,0 +1,183 @@
+// Package events is an in-process bus for domain events. Services record
+// events in the outbox with the change they describe, and the outbox
+// dispatcher publishes them once committed; monitors, audit logging and
+// integrations subscribe to the types they need.
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+// Event types.
+const (
+	FlightChanged  = "flight.changed"  // Seats, fares or schedule of a flight changed
+	BookingCreated = "booking.created" // A booking was recorded
+	PointsChanged  = "points.changed"  // A client's points balance changed
+
+	// Reserved for booking cancellation, which no code path offers yet
+	BookingCancelled = "booking.cancelled"
+)
+
+// Types lists every event type.
+var Types = []string{FlightChanged, BookingCreated, BookingCancelled, PointsChanged}
+
+// Event describes one committed change. Only the fields relevant to its
+// type are set.
+type Event struct {
+	Key        string // Dedup key, the same on every delivery of the event
+	Type       string
+	FlightID   int
+	BookingID  uint
+	ClientAddr string
+	Change     string // The models.Change* constant for flights, the entry type for points
+	Seats      int
+	Amount     models.Money // Booking total, or points balance after the change
+	OccurredAt time.Time
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case FlightChanged:
+		return fmt.Sprintf("%s flight=%d change=%s", e.Type, e.FlightID, e.Change)
+	case BookingCreated:
+		return fmt.Sprintf("%s booking=%d flight=%d client=%s seats=%d total=%s", e.Type, e.BookingID, e.FlightID, e.ClientAddr, e.Seats, e.Amount)
+	case PointsChanged:
+		return fmt.Sprintf("%s client=%s change=%s balance=%s", e.Type, e.ClientAddr, e.Change, e.Amount)
+	}
+	return e.Type
+}
+
+// FlightChange returns a FlightChanged event.
+func FlightChange(flightID int, change string) Event {
+	return Event{Type: FlightChanged, FlightID: flightID, Change: change, OccurredAt: time.Now()}
+}
+
+// BookingCreation returns a BookingCreated event, keyed by the booking.
+func BookingCreation(booking models.Booking) Event {
+	return Event{
+		Key:        fmt.Sprintf("%s:%d", BookingCreated, booking.ID),
+		Type:       BookingCreated,
+		FlightID:   booking.FlightID,
+		BookingID:  booking.ID,
+		ClientAddr: booking.ClientAddr,
+		Seats:      booking.Seats,
+		Amount:     booking.TotalPrice,
+		OccurredAt: time.Now(),
+	}
+}
+
+// PointsChange returns a PointsChanged event for a ledger entry, keyed by
+// the entry and carrying the balance after it.
+func PointsChange(entry models.PointsEntry) Event {
+	return Event{
+		Key:        fmt.Sprintf("%s:%d", PointsChanged, entry.ID),
+		Type:       PointsChanged,
+		ClientAddr: entry.ClientAddr,
+		Change:     entry.Type,
+		Amount:     entry.Balance,
+		OccurredAt: entry.CreatedAt,
+	}
+}
+
+// Handler receives published events. An error asks for the event to be
+// delivered again later.
+type Handler func(Event) error
+
+// Bus delivers each event to the handlers subscribed to its type and then
+// to those subscribed to every type, in subscription order, on the
+// publisher's goroutine. A nil Bus discards events.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	all      []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe registers a handler for one event type.
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// SubscribeAll registers a handler for every event type.
+func (b *Bus) SubscribeAll(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.all = append(b.all, handler)
+}
+
+// Publish delivers events in order. A failing or panicking handler does not
+// stop delivery to the others; the failures are returned together so the
+// caller can deliver the event again.
+func (b *Bus) Publish(events ...Event) error {
+	if b == nil {
+		return nil
+	}
+	var failures []error
+	for _, event := range events {
+		b.mu.RLock()
+		handlers := append(append([]Handler(nil), b.handlers[event.Type]...), b.all...)
+		b.mu.RUnlock()
+		for _, handler := range handlers {
+			if err := deliver(handler, event); err != nil {
+				failures = append(failures, err)
+			}
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// Deduplicate wraps a handler so it skips events whose key it has handled
+// among the last capacity keys. Events are delivered at least once, and
+// again to every handler when any of them fails, so handlers with visible
+// effects should be wrapped. A key is only remembered once the handler
+// succeeds, so a failed event is retried.
+func Deduplicate(handler Handler, capacity int) Handler {
+	var mu sync.Mutex
+	seen := make(map[string]bool, capacity)
+	var order []string
+	return func(event Event) error {
+		if event.Key == "" {
+			return handler(event)
+		}
+		mu.Lock()
+		duplicate := seen[event.Key]
+		mu.Unlock()
+		if duplicate {
+			return nil
+		}
+		if err := handler(event); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[event.Key] {
+			seen[event.Key] = true
+			order = append(order, event.Key)
+			if len(order) > capacity {
+				delete(seen, order[0])
+				order = order[1:]
+			}
+		}
+		return nil
+	}
+}
+
+// deliver runs one handler, turning a panic into an error.
+func deliver(handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked on %s: %v", event, r)
+		}
+	}()
+	return handler(event)
+}
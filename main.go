@@ -1,20 +1,61 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/Guesstrain/airline/clock"
+	"github.com/Guesstrain/airline/events"
 	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/monitor"
+	"github.com/Guesstrain/airline/pricing"
 	"github.com/Guesstrain/airline/service"
 	"github.com/Guesstrain/airline/utility"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-var monitors = map[int][]*models.ClientInfo{}
-var processedRequests = make(map[string]bool)
+const holdReaperInterval = 10 * time.Second
+const tierReviewInterval = time.Hour
+const monitorSweepInterval = 30 * time.Second
+const retransmitInterval = time.Second
+const routeWatchInterval = 30 * time.Second
+const heartbeatInterval = 15 * time.Second
+const heartbeatListBudget = 768 // Bytes of monitor lists a heartbeat may carry
+const outboxDispatchInterval = 200 * time.Millisecond
+const outboxRetention = 7 * 24 * time.Hour
+const eventDedupCapacity = 10000
+const webhookDeliveryInterval = time.Second
+const pointsExpiryInterval = time.Hour
+const pointsLifetime = service.DefaultPointsLifetime
+const replyCacheTTL = 10 * time.Minute // How long retried requests get their original replies
+const replyCacheSweepInterval = time.Minute
+
+var pricingEngine pricing.Engine = pricing.DefaultEngine()
+var quoteSecret = loadQuoteSecret()
+var loyaltyProgram = models.DefaultLoyaltyProgram()
+var waitlistPolicy = loadWaitlistPolicy()
+
+var monitors = monitor.NewRegistry(monitor.DefaultMaxPerClient, monitor.DefaultMaxPerFlight)
+var deliveries = monitor.NewDeliveries(monitor.DefaultRetryBackoff, monitor.DefaultMaxAttempts)
+var routeWatcher = monitor.NewRouteWatcher()
+var bus = events.NewBus()
+
+// serverEpoch changes on every restart, so clients can tell when sequence
+// numbers have started over.
+var serverEpoch = strconv.FormatInt(time.Now().UnixNano(), 10)
+var processedRequests = newReplyCache()
+
+// Requests that change state and must not be executed twice when a client retries.
+var nonIdempotentRequests = map[int]bool{3: true, 6: true, 8: true, 11: true, 12: true, 13: true, 14: true, 16: true, 20: true, 21: true, 29: true, 31: true, 32: true}
 
 func main() {
 	dsn := "root:password@tcp(127.0.0.1:3306)/airline?charset=utf8mb4&parseTime=True&loc=Local"
@@ -22,6 +63,15 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to connect to MySQL database:", err)
 	}
+	if err := service.MigrateMoneyColumns(db); err != nil {
+		log.Fatal("Failed to convert amounts to minor units:", err)
+	}
+	if err := service.DropStaleIndexes(db); err != nil {
+		log.Fatal("Failed to drop old indexes:", err)
+	}
+	if err := db.AutoMigrate(&models.Flight{}, &models.FareClass{}, &models.Booking{}, &models.Quote{}, &models.Airport{}, &models.Seat{}, &models.SeatHold{}, &models.WaitlistEntry{}, &models.ClientPoints{}, &models.PointsEntry{}, &models.PointsLot{}, &models.Transfer{}, &models.MonitorRegistration{}, &models.OutboxEvent{}, &models.WebhookEndpoint{}, &models.WebhookDelivery{}); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
 
 	addr, err := net.ResolveUDPAddr("udp", ":8080")
 	if err != nil {
@@ -38,14 +88,116 @@ func main() {
 
 	fmt.Println("Server listening on port 8080")
 
+	seedAirports(db)
+	auditFlightAirports(&service.AirportServiceImpl{DB: db})
+	subscribeMonitors(conn, &service.FlightServiceImpl{DB: db, Pricing: pricingEngine})
+	subscribeRouteMonitors(conn, &service.FlightServiceImpl{DB: db, Pricing: pricingEngine})
+	bus.SubscribeAll(auditEvent)
+	subscribeWebhooks(&service.WebhookServiceImpl{DB: db})
+	go runWebhookDelivery(&service.WebhookServiceImpl{DB: db})
+	// Monitors must be back before queued events are dispatched to them
+	restoreMonitors(conn, &service.MonitorServiceImpl{DB: db}, &service.FlightServiceImpl{DB: db, Pricing: pricingEngine})
+	go runOutboxDispatcher(&service.OutboxServiceImpl{DB: db, Events: bus})
+	go runHoldReaper(conn,
+		&service.HoldServiceImpl{DB: db, Pricing: pricingEngine},
+		&service.WaitlistServiceImpl{DB: db, Policy: waitlistPolicy, Pricing: pricingEngine, Loyalty: loyaltyProgram})
+	go runMonitorSweeper(conn, &service.MonitorServiceImpl{DB: db})
+	go runCallbackRetransmitter(conn)
+	go runHeartbeat(conn)
+	go runRouteWatcher(conn, &service.FlightServiceImpl{DB: db, Pricing: pricingEngine})
+	go runTierReview(&service.PointsServiceImpl{DB: db, Loyalty: loyaltyProgram, Lifetime: pointsLifetime})
+	go runReplyCacheSweeper()
+	go runPointsExpiry(&service.PointsServiceImpl{DB: db, Loyalty: loyaltyProgram, Lifetime: pointsLifetime, Clock: clock.Real{}})
+
 	for {
 		handleRequest(conn, db)
 	}
 }
 
+// udpWriter is the part of a UDP connection the request handlers reply through.
+type udpWriter interface {
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// replyRecorder keeps a copy of every reply sent to the requesting client.
+type replyRecorder struct {
+	udpWriter
+	client  *net.UDPAddr
+	replies [][]byte
+}
+
+func (r *replyRecorder) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if addr.String() == r.client.String() {
+		r.replies = append(r.replies, append([]byte(nil), b...))
+	}
+	return r.udpWriter.WriteToUDP(b, addr)
+}
+
+// replyCache keeps the replies sent to non-idempotent requests, keyed by
+// client and request ID, until replyCacheTTL has passed.
+type replyCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedReplies
+}
+
+type cachedReplies struct {
+	replies [][]byte
+	at      time.Time
+}
+
+func newReplyCache() *replyCache {
+	return &replyCache{entries: make(map[string]cachedReplies)}
+}
+
+func (c *replyCache) get(key string) ([][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry.replies, ok
+}
+
+func (c *replyCache) put(key string, replies [][]byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cachedReplies{replies: replies, at: now}
+}
+
+// sweep drops the replies stored before the given time and returns how many
+// requests were forgotten.
+func (c *replyCache) sweep(before time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, entry := range c.entries {
+		if entry.at.Before(before) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// runReplyCacheSweeper forgets replies older than replyCacheTTL. A client
+// retrying after that long has given up on the original request.
+func runReplyCacheSweeper() {
+	ticker := time.NewTicker(replyCacheSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		processedRequests.sweep(time.Now().Add(-replyCacheTTL))
+	}
+}
+
 func handleRequest(conn *net.UDPConn, db *gorm.DB) {
-	flightService := &service.FlightServiceImpl{DB: db}
-	pointsService := &service.PointsServiceImpl{DB: db}
+	quoteService := &service.QuoteServiceImpl{DB: db, Pricing: pricingEngine, Secret: quoteSecret}
+	pointsService := &service.PointsServiceImpl{DB: db, Loyalty: loyaltyProgram, Lifetime: pointsLifetime, TransferLimit: service.DefaultTransferLimit}
+	flightService := &service.FlightServiceImpl{DB: db, Pricing: pricingEngine, Quotes: quoteService, Points: pointsService}
+	itineraryService := &service.ItineraryServiceImpl{DB: db, Pricing: pricingEngine}
+	airportService := &service.AirportServiceImpl{DB: db}
+	holdService := &service.HoldServiceImpl{DB: db, Pricing: pricingEngine, Quotes: quoteService}
+	waitlistService := &service.WaitlistServiceImpl{DB: db, Policy: waitlistPolicy, Pricing: pricingEngine, Loyalty: loyaltyProgram}
+	seatService := &service.SeatServiceImpl{DB: db, Pricing: pricingEngine}
+	monitorService := &service.MonitorServiceImpl{DB: db}
+	webhookService := &service.WebhookServiceImpl{DB: db}
 	buffer := make([]byte, 1024)
 	_, clientAddr, err := conn.ReadFromUDP(buffer)
 	if err != nil {
@@ -57,63 +209,210 @@ func handleRequest(conn *net.UDPConn, db *gorm.DB) {
 		fmt.Println("Error DeserializeFlight:", err)
 		return
 	}
-	if _, exists := processedRequests[requestID]; (requestType == 3 || requestType == 6) && exists {
-		fmt.Println("Duplicate request detected, ignoring...")
-		response, _ := utility.SerializeFlights([]models.Flight{}, byte(requestType), 0, "Duplicate request, executed already")
-		conn.WriteToUDP(response, clientAddr)
+	// Replies to a retried request are resent as they were, so the client
+	// still gets the hold token or secret it is retrying for.
+	requestKey := clientAddr.String() + "/" + requestID
+	if replies, exists := processedRequests.get(requestKey); nonIdempotentRequests[requestType] && exists {
+		fmt.Println("Duplicate request detected, resending reply...")
+		if len(replies) == 0 {
+			response, _ := utility.SerializeFlights([]models.Flight{}, byte(requestType), 0, "Duplicate request, executed already")
+			replies = [][]byte{response}
+		}
+		for _, reply := range replies {
+			conn.WriteToUDP(reply, clientAddr)
+		}
 		return
 	}
-	if requestID != "" {
-		processedRequests[requestID] = true
+	recorder := &replyRecorder{udpWriter: conn, client: clientAddr}
+	if requestID != "" && nonIdempotentRequests[requestType] {
+		defer func() { processedRequests.put(requestKey, recorder.replies, time.Now()) }()
 	}
 	// fmt.Println("flight info is", flight)
 
 	switch requestType {
 	case 1: // Query flights by source and destination
-		respondQueryFlights(conn, clientAddr, flightService, flight.Source, flight.Destination)
+		respondQueryFlights(recorder, clientAddr, flightService, flight.Source, flight.Destination, flight.Cursor)
 		fmt.Println(clientAddr, "Query flights by source and destination")
 
 	case 2: // Query flight details by flight ID
-		respondFlightDetails(conn, clientAddr, flightService, flight.ID)
+		respondFlightDetails(recorder, clientAddr, flightService, flight.ID)
 		fmt.Println(clientAddr, "Query flight details by flight ID")
 
 	case 3: // Make a seat reservation
-		respondSeatReservation(conn, clientAddr, flightService, pointsService, flight.ID, flight.SeattoBook)
+		respondSeatReservation(recorder, clientAddr, flightService, pointsService, flight.ID, flight.SeattoBook, flight.FareClass, flight.QuoteID)
 		fmt.Println(clientAddr, "Make a seat reservation")
 
 	case 4: // Monitor seat availability
-		registerForMonitoring(conn, clientAddr, flight.ID, flight.Duration)
+		condition := flight.Condition
+		condition.FareClass = flight.FareClass
+		registerForMonitoring(recorder, clientAddr, flightService, monitorService, flight.ID, flight.Duration, flight.NotifyEnd, condition)
 		fmt.Println(clientAddr, "Monitor seat availability")
 
 	case 5: // Query points based on client address
-		respondQueryPoints(conn, clientAddr, pointsService)
+		respondQueryPoints(recorder, clientAddr, pointsService)
 		fmt.Println(clientAddr, "Queried points")
 
 	case 6: // Make a seat reservation with points
-		respondUsingPoints(conn, clientAddr, flightService, pointsService, flight.ID, flight.SeattoBook)
+		respondUsingPoints(recorder, clientAddr, flightService, pointsService, quoteService, flight.ID, flight.SeattoBook, flight.FareClass, flight.QuoteID)
 		fmt.Println(clientAddr, "Make a seat reservation with points")
+
+	case 7: // Search connecting itineraries
+		respondSearchItineraries(recorder, clientAddr, itineraryService, flight)
+		fmt.Println(clientAddr, "Search connecting itineraries")
+
+	case 8: // Book an itinerary as a unit
+		respondBookItinerary(recorder, clientAddr, itineraryService, pointsService, flight.FlightIDs, flight.SeattoBook, flight.FareClass)
+		fmt.Println(clientAddr, "Book an itinerary")
+
+	case 9: // Search flights with filters, sorting and pagination
+		respondSearchFlights(recorder, clientAddr, flightService, itineraryService, flight)
+		fmt.Println(clientAddr, "Search flights with filters")
+
+	case 10: // Autocomplete airports and cities
+		respondAutocompleteAirports(recorder, clientAddr, airportService, flight.Source, flight.PageSize)
+		fmt.Println(clientAddr, "Autocomplete airports")
+
+	case 11: // Hold seats while the client confirms
+		respondHoldSeats(recorder, clientAddr, holdService, flight.ID, flight.SeattoBook, flight.FareClass, flight.QuoteID, flight.Duration)
+		fmt.Println(clientAddr, "Hold seats")
+
+	case 12: // Confirm a seat hold
+		respondConfirmHold(recorder, clientAddr, holdService, pointsService, flight.HoldToken)
+		fmt.Println(clientAddr, "Confirm seat hold")
+
+	case 13: // Join the waitlist for a sold-out flight
+		respondJoinWaitlist(recorder, clientAddr, waitlistService, flight.ID, flight.SeattoBook, flight.FareClass)
+		fmt.Println(clientAddr, "Join waitlist")
+
+	case 14: // Cancel a seat hold
+		respondReleaseHold(recorder, clientAddr, holdService, waitlistService, flight.HoldToken)
+		fmt.Println(clientAddr, "Cancel seat hold")
+
+	case 15: // Fetch a flight's seat map
+		respondSeatMap(recorder, clientAddr, seatService, flight.ID, flight.FromRow, flight.ToRow)
+		fmt.Println(clientAddr, "Fetch seat map")
+
+	case 16: // Reserve specific seats
+		respondReserveSeatNumbers(recorder, clientAddr, seatService, pointsService, flight.ID, flight.SeatNumbers)
+		fmt.Println(clientAddr, "Reserve specific seats")
+
+	case 17: // Quote a guaranteed price
+		respondCreateQuote(recorder, clientAddr, quoteService, flight.ID, flight.SeattoBook, flight.FareClass, flight.Duration)
+		fmt.Println(clientAddr, "Quote a price")
+
+	case 18: // Points statement
+		respondPointsStatement(recorder, clientAddr, pointsService, flight.Cursor, flight.PageSize)
+		fmt.Println(clientAddr, "Points statement")
+
+	case 19: // Points due to expire soon
+		respondExpiringPoints(recorder, clientAddr, pointsService, flight.Duration)
+		fmt.Println(clientAddr, "Expiring points")
+
+	case 20: // Transfer points to another client
+		key := flight.IdempotencyKey
+		if key == "" {
+			key = requestID
+		}
+		respondTransferPoints(recorder, clientAddr, pointsService, flight.Recipient, flight.Points, key)
+		fmt.Println(clientAddr, "Transfer points")
+
+	case 21: // Make a seat reservation paid partly with points
+		respondMixedPayment(recorder, clientAddr, flightService, pointsService, flight.ID, flight.SeattoBook, flight.FareClass, flight.QuoteID, flight.Points)
+		fmt.Println(clientAddr, "Reserve with cash and points")
+
+	case 22: // Stop monitoring a flight, or a route when no flight is given
+		if flight.ID == 0 && flight.Source != "" {
+			respondCancelRouteMonitor(recorder, clientAddr, monitorService, flight.Source, flight.Destination)
+		} else {
+			respondCancelMonitor(recorder, clientAddr, monitorService, flight.ID)
+		}
+		fmt.Println(clientAddr, "Cancel monitor")
+
+	case 23: // Extend a flight monitor
+		respondRenewMonitor(recorder, clientAddr, monitorService, flight.ID, flight.Duration)
+		fmt.Println(clientAddr, "Renew monitor")
+
+	case 24: // List the client's monitors
+		respondListMonitors(recorder, clientAddr)
+		fmt.Println(clientAddr, "List monitors")
+
+	case 25: // Acknowledge a monitor callback
+		subject := monitor.FlightSubject(flight.ID)
+		if flight.ID == 0 && flight.Source != "" {
+			subject = monitor.RouteSubject(flight.Source, flight.Destination)
+		}
+		deliveries.Ack(clientAddr, subject, flight.Sequence)
+
+	case 26: // Resend the current state of a monitored flight
+		respondResync(recorder, clientAddr, flightService, flight.ID)
+		fmt.Println(clientAddr, "Resync monitor")
+
+	case 27: // Monitor every flight on a route
+		request := models.RouteMonitor{
+			ClientAddr:   clientAddr,
+			Source:       flight.Source,
+			Destination:  flight.Destination,
+			DepartAfter:  flight.DepartAfter,
+			DepartBefore: flight.DepartBefore,
+			NotifyEnd:    flight.NotifyEnd,
+		}
+		registerRouteMonitor(recorder, clientAddr, flightService, airportService, monitorService, request, flight.Duration)
+		fmt.Println(clientAddr, "Monitor route")
+
+	case 28: // Ping
+		respondPing(recorder, clientAddr)
+
+	case 29: // Register a webhook endpoint (operators only)
+		respondRegisterWebhook(recorder, clientAddr, webhookService, flight.WebhookURL, flight.EventType)
+		fmt.Println(clientAddr, "Register webhook")
+
+	case 30: // Replay webhook deliveries (operators only)
+		respondReplayWebhooks(recorder, clientAddr, webhookService, uint(flight.ID))
+		fmt.Println(clientAddr, "Replay webhooks")
+
+	case 31: // Leave the waitlist for a flight
+		respondLeaveWaitlist(recorder, clientAddr, waitlistService, flight.ID)
+		fmt.Println(clientAddr, "Leave waitlist")
+
+	case 32: // Generate a flight's seat map (operators only)
+		respondGenerateSeatMap(recorder, clientAddr, seatService, flight.ID, flight.SeatLayout)
+		fmt.Println(clientAddr, "Generate seat map")
 	}
 }
 
-func respondQueryFlights(conn *net.UDPConn, clientAddr *net.UDPAddr, service service.FlightService, source, destination string) {
-	flights, err := service.QueryFlights(source, destination)
+// respondQueryFlights replies with as many flights as fit in a datagram,
+// and a next_cursor attribute when more remain.
+func respondQueryFlights(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, source, destination, cursor string) {
+	offset, err := service.DecodeCursor(cursor)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 1, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	flights, err := flightService.QueryFlights(source, destination)
 	if err != nil {
-		response, _ := utility.SerializeFlights(flights, 1, 1, "Error querying flights")
+		response, _ := utility.SerializeFlights([]models.Flight{}, 1, 1, "Error querying flights")
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
-	if len(flights) == 0 {
-		response, _ := utility.SerializeFlights(flights, 1, 0, "No flights found")
+	if offset >= len(flights) {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 1, 0, "No flights found")
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
 
-	var response []byte
-	response, _ = utility.SerializeFlights(flights, 1, 0, "Success")
+	flights = flights[offset:]
+	response, _, _ := utility.Fit(len(flights), func(n int) ([]byte, error) {
+		attrs := map[string]string{}
+		if n < len(flights) {
+			attrs["next_cursor"] = service.EncodeCursor(offset + n)
+		}
+		return utility.SerializeFlightsWithAttributes(flights[:n], 1, 0, "Success", attrs)
+	})
 	conn.WriteToUDP(response, clientAddr)
 }
 
-func respondFlightDetails(conn *net.UDPConn, clientAddr *net.UDPAddr, service service.FlightService, flightID int) {
+func respondFlightDetails(conn udpWriter, clientAddr *net.UDPAddr, service service.FlightService, flightID int) {
 	flight, err := service.GetFlightDetails(flightID)
 	flights := []models.Flight{*flight}
 	if err != nil {
@@ -125,69 +424,625 @@ func respondFlightDetails(conn *net.UDPConn, clientAddr *net.UDPAddr, service se
 	conn.WriteToUDP(response, clientAddr)
 }
 
-func respondUsingPoints(conn *net.UDPConn, clientAddr *net.UDPAddr, flightService service.FlightService, pointsService service.PointsService, flightID, seats int) {
+func respondUsingPoints(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, pointsService service.PointsService, quoteService service.QuoteService, flightID, seats int, fareClass, quoteID string) {
 	flight, err := flightService.GetFlightDetails(flightID)
 	if err != nil {
 		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
-	clientPoints, _ := pointsService.QueryPoints(clientAddr.String())
-	if clientPoints.Points < (flight.Airfare * float64(seats)) {
-		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, "Not Enough Points")
+	fare, _, err := flight.Fare(fareClass)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
-	*flight, err = flightService.ReserveSeats(flightID, seats)
+	if quoteID != "" {
+		quote, err := quoteService.GetQuote(quoteID)
+		if err != nil {
+			response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
+			conn.WriteToUDP(response, clientAddr)
+			return
+		}
+		fare = quote.UnitPrice
+	}
+	clientPoints, _ := pointsService.QueryPoints(clientAddr.String())
+	cost, err := pointsService.RedemptionCost(clientAddr.String(), fare.Times(seats))
 	if err != nil {
 		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
-	clientPoints.Points = clientPoints.Points - flight.Airfare*float64(seats)
-	fmt.Println("clientPoints.Points: ", clientPoints.Points)
-	fmt.Println("flight.Airfare: ", flight.Airfare)
-	_, err = pointsService.UpdatePoints(clientAddr.String(), clientPoints.Points)
-	if err != nil {
-		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
+	if clientPoints.Points < cost {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, "Not Enough Points")
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if _, _, err := flightService.ReserveSeatsWithPoints(clientAddr.String(), flightID, seats, fareClass, quoteID, 0); err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 3, statusFor(err), err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
 
 	response, _ := utility.SerializeFlights([]models.Flight{}, 3, 0, "Reservation using points successful")
 	conn.WriteToUDP(response, clientAddr)
-	notifyMonitors(conn, flightID, flight.SeatAvailability)
 }
 
-func respondSeatReservation(conn *net.UDPConn, clientAddr *net.UDPAddr, flightService service.FlightService, pointsService service.PointsService, flightID, seats int) {
-	flight, err := flightService.ReserveSeats(flightID, seats)
+// respondMixedPayment reserves seats paying up to the given points toward
+// the price and the rest in cash. Points are only earned on the cash part.
+func respondMixedPayment(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, pointsService service.PointsService, flightID, seats int, fareClass, quoteID string, points models.Money) {
+	if points <= 0 {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 21, 1, "Invalid number of points")
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	// The balance is checked against the points actually used, at most the
+	// fare, inside the reservation
+	booking, flight, err := flightService.ReserveSeatsWithPoints(clientAddr.String(), flightID, seats, fareClass, quoteID, points)
 	if err != nil {
-		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
+		response, _ := utility.SerializeFlights([]models.Flight{}, 21, statusFor(err), err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
-	clientPoints, _ := pointsService.QueryPoints(clientAddr.String())
-	clientPoints.Points = clientPoints.Points + flight.Airfare*float64(seats)
+	if booking.PaidCash > 0 {
+		_, earnRate, _ := flight.Fare(fareClass)
+		earnPoints(pointsService, clientAddr, booking.PaidCash.Scale(earnRate), booking.ID, fmt.Sprintf("Flight %d", flightID))
+	}
 
-	_, err = pointsService.UpdatePoints(clientAddr.String(), clientPoints.Points)
+	attrs := map[string]string{
+		"booking_id":  strconv.FormatUint(uint64(booking.ID), 10),
+		"paid_points": booking.PaidPoints.String(),
+		"paid_cash":   booking.PaidCash.String(),
+	}
+	message := fmt.Sprintf("Reservation successful: %s points and %s cash", booking.PaidPoints, booking.PaidCash)
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 21, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondSeatReservation(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, pointsService service.PointsService, flightID, seats int, fareClass, quoteID string) {
+	booking, flight, err := flightService.ReserveSeats(clientAddr.String(), flightID, seats, fareClass, quoteID)
 	if err != nil {
-		response, _ := utility.SerializeFlights([]models.Flight{}, 3, 1, err.Error())
+		response, _ := utility.SerializeFlights([]models.Flight{}, 3, statusFor(err), err.Error())
 		conn.WriteToUDP(response, clientAddr)
 		return
 	}
+	_, earnRate, _ := flight.Fare(fareClass)
+	earnPoints(pointsService, clientAddr, booking.TotalPrice.Scale(earnRate), booking.ID, fmt.Sprintf("Flight %d", flightID))
 
 	response, _ := utility.SerializeFlights([]models.Flight{}, 3, 0, "Reservation successful")
 	conn.WriteToUDP(response, clientAddr)
-	notifyMonitors(conn, flightID, flight.SeatAvailability)
 }
 
-func registerForMonitoring(conn *net.UDPConn, clientAddr *net.UDPAddr, flightID int, duration time.Duration) {
-	clientInfo := &models.ClientInfo{clientAddr, time.Now().Add(duration)}
+// earnPoints credits the points a committed booking earns. The booking
+// stands whether or not this succeeds, so a failure is logged rather than
+// reported to the client as a failed reservation.
+func earnPoints(pointsService service.PointsService, clientAddr *net.UDPAddr, amount models.Money, bookingID uint, description string) {
+	if _, err := pointsService.Earn(clientAddr.String(), amount, bookingID, description); err != nil {
+		fmt.Printf("Error earning points for booking %d: %v\n", bookingID, err)
+	}
+}
+
+func respondSearchItineraries(conn udpWriter, clientAddr *net.UDPAddr, itineraryService service.ItineraryService, request models.RequestFlight) {
+	page, err := itineraryService.SearchItineraries(models.ItineraryQuery{
+		Source:        request.Source,
+		Destination:   request.Destination,
+		SearchFilters: searchFilters(request),
+		MaxStops:      request.MaxStops,
+		MinLayover:    request.MinLayover,
+		MaxLayover:    request.MaxLayover,
+		SortBy:        request.SortBy,
+		Cursor:        request.Cursor,
+		PageSize:      request.PageSize,
+	})
+	if err != nil {
+		response, _ := utility.SerializeItineraries(nil, 7, 1, "Error searching itineraries", nil)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if len(page.Itineraries) == 0 {
+		response, _ := utility.SerializeItineraries(nil, 7, 0, "No itineraries found", nil)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _, _ := utility.Fit(len(page.Itineraries), func(n int) ([]byte, error) {
+		trimmed := page
+		trimmed.Itineraries = page.Itineraries[:n]
+		if n < len(page.Itineraries) {
+			trimmed.NextCursor = service.EncodeCursor(page.Offset + n)
+		}
+		return utility.SerializeItineraryPage(trimmed, 7, 0, "Success")
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// respondSearchFlights answers a filtered search. Direct-only searches page
+// through flights; searches that allow stops page through itineraries and
+// reply with opcode 7 so clients know to decode the itinerary layout.
+func respondSearchFlights(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, itineraryService service.ItineraryService, request models.RequestFlight) {
+	if request.MaxStops > 0 {
+		respondSearchItineraries(conn, clientAddr, itineraryService, request)
+		return
+	}
+	page, err := flightService.SearchFlights(models.FlightSearch{
+		Source:        request.Source,
+		Destination:   request.Destination,
+		SearchFilters: searchFilters(request),
+		SortBy:        request.SortBy,
+		Cursor:        request.Cursor,
+		PageSize:      request.PageSize,
+	})
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 9, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if len(page.Flights) == 0 {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 9, 0, "No flights found")
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _, _ := utility.Fit(len(page.Flights), func(n int) ([]byte, error) {
+		trimmed := page
+		trimmed.Flights = page.Flights[:n]
+		if n < len(page.Flights) {
+			trimmed.NextCursor = service.EncodeCursor(page.Offset + n)
+		}
+		return utility.SerializeFlightPage(trimmed, 9, 0, "Success")
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func searchFilters(request models.RequestFlight) models.SearchFilters {
+	minSeats := request.MinSeats
+	if minSeats == 0 {
+		minSeats = request.SeattoBook
+	}
+	return models.SearchFilters{
+		MinPrice:     request.MinPrice,
+		MaxPrice:     request.MaxPrice,
+		MinSeats:     minSeats,
+		DepartAfter:  request.DepartAfter,
+		DepartBefore: request.DepartBefore,
+		Carrier:      request.Carrier,
+	}
+}
+
+func respondBookItinerary(conn udpWriter, clientAddr *net.UDPAddr, itineraryService service.ItineraryService, pointsService service.PointsService, flightIDs []int, seats int, fareClass string) {
+	bookings, legs, err := itineraryService.BookItinerary(clientAddr.String(), flightIDs, seats, fareClass)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 8, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	for i, leg := range legs {
+		_, earnRate, _ := leg.Fare(fareClass)
+		earnPoints(pointsService, clientAddr, bookings[i].TotalPrice.Scale(earnRate), bookings[i].ID, fmt.Sprintf("Flight %d", leg.ID))
+	}
+
+	response, _ := utility.SerializeFlights(legs, 8, 0, "Itinerary booked")
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondAutocompleteAirports(conn udpWriter, clientAddr *net.UDPAddr, service service.AirportService, prefix string, limit int) {
+	airports, err := service.Autocomplete(prefix, limit)
+	if err != nil {
+		response, _ := utility.SerializeAirports(nil, 10, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if len(airports) == 0 {
+		response, _ := utility.SerializeAirports(nil, 10, 0, "No airports found")
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _, _ := utility.Fit(len(airports), func(n int) ([]byte, error) {
+		return utility.SerializeAirports(airports[:n], 10, 0, "Success")
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondHoldSeats(conn udpWriter, clientAddr *net.UDPAddr, holdService service.HoldService, flightID, seats int, fareClass, quoteID string, duration time.Duration) {
+	hold, flight, err := holdService.HoldSeats(clientAddr.String(), flightID, seats, fareClass, quoteID, duration)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 11, statusFor(err), err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+
+	attrs := map[string]string{
+		"hold_token": hold.Token,
+		"expires_at": hold.ExpiresAt.Format(time.RFC3339),
+		"unit_price": hold.UnitPrice.String(),
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{flight}, 11, 0, "Seats held", attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondConfirmHold(conn udpWriter, clientAddr *net.UDPAddr, holdService service.HoldService, pointsService service.PointsService, token string) {
+	hold, booking, flight, err := holdService.ConfirmHold(token, clientAddr.String())
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 12, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	_, earnRate, _ := flight.Fare(hold.FareClass)
+	earnPoints(pointsService, clientAddr, booking.TotalPrice.Scale(earnRate), booking.ID, fmt.Sprintf("Flight %d", flight.ID))
+
+	response, _ := utility.SerializeFlights([]models.Flight{flight}, 12, 0, "Reservation successful")
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondJoinWaitlist(conn udpWriter, clientAddr *net.UDPAddr, waitlistService service.WaitlistService, flightID, seats int, fareClass string) {
+	entry, position, err := waitlistService.Join(clientAddr.String(), flightID, seats, fareClass)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 13, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{
+		"waitlist_id": fmt.Sprintf("%d", entry.ID),
+		"position":    fmt.Sprintf("%d", position),
+	}
+	message := fmt.Sprintf("Waitlisted for %d seat(s) on flight %d, position %d", entry.Seats, flightID, position)
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 13, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondLeaveWaitlist(conn udpWriter, clientAddr *net.UDPAddr, waitlistService service.WaitlistService, flightID int) {
+	entry, err := waitlistService.Leave(clientAddr.String(), flightID)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 31, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{"waitlist_id": fmt.Sprintf("%d", entry.ID)}
+	message := fmt.Sprintf("Left the waitlist for flight %d", flightID)
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 31, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondReleaseHold(conn udpWriter, clientAddr *net.UDPAddr, holdService service.HoldService, waitlistService service.WaitlistService, token string) {
+	hold, err := holdService.ReleaseHold(token, clientAddr.String())
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 14, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _ := utility.SerializeFlights([]models.Flight{}, 14, 0, "Hold cancelled")
+	conn.WriteToUDP(response, clientAddr)
+
+	offers, err := waitlistService.Allocate(hold.FlightID)
+	if err != nil {
+		fmt.Println("Error allocating waitlist:", err)
+	}
+	notifyWaitlistOffers(conn, offers)
+}
+
+func respondCreateQuote(conn udpWriter, clientAddr *net.UDPAddr, quoteService service.QuoteService, flightID, seats int, fareClass string, validity time.Duration) {
+	quote, err := quoteService.CreateQuote(clientAddr.String(), flightID, seats, fareClass, validity)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 17, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{
+		"quote_id":    quote.ID,
+		"unit_price":  quote.UnitPrice.String(),
+		"total_price": quote.TotalPrice.String(),
+		"seats":       fmt.Sprintf("%d", quote.Seats),
+		"expires_at":  quote.ExpiresAt.Format(time.RFC3339),
+	}
+	message := fmt.Sprintf("%d seat(s) at %s guaranteed until %s", quote.Seats, quote.TotalPrice, quote.ExpiresAt.Format(time.RFC3339))
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 17, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// statusFor returns the reply status code for a failed request: 2 when a
+// quote has expired, 1 for any other error.
+func statusFor(err error) byte {
+	if errors.Is(err, service.ErrQuoteExpired) {
+		return 2
+	}
+	return 1
+}
+
+// loadQuoteSecret reads the quote signing key from QUOTE_SECRET. Without it a
+// random key is used, so quotes do not survive a restart.
+func loadQuoteSecret() []byte {
+	if secret := os.Getenv("QUOTE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Failed to generate quote secret:", err)
+	}
+	return secret
+}
+
+// loadWaitlistPolicy reads the waitlist ordering from WAITLIST_POLICY,
+// defaulting to first come, first served.
+func loadWaitlistPolicy() service.WaitlistPolicy {
+	name := os.Getenv("WAITLIST_POLICY")
+	if name == "" {
+		return service.WaitlistFIFO
+	}
+	policy, err := service.ParseWaitlistPolicy(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return policy
+}
+
+// runHoldReaper periodically releases expired holds and offers free seats
+// to waitlisted customers. Monitors hear of the seat changes through the
+// events the services record. Allocation runs on every tick so seats freed
+// by any path, including inventory increases, reach the waitlist.
+func runHoldReaper(conn *net.UDPConn, holdService service.HoldService, waitlistService service.WaitlistService) {
+	ticker := time.NewTicker(holdReaperInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		holds, err := holdService.ReleaseExpired(time.Now())
+		if err != nil {
+			fmt.Println("Error releasing expired holds:", err)
+		}
+		for _, hold := range holds {
+			fmt.Println("Released expired hold", hold.Token, "on flight", hold.FlightID)
+		}
+
+		offers, err := waitlistService.AllocatePending()
+		if err != nil {
+			fmt.Println("Error allocating waitlist:", err)
+		}
+		notifyWaitlistOffers(conn, offers)
+	}
+}
+
+// notifyWaitlistOffers tells waitlisted customers, over the monitor callback
+// channel, that seats are being held for them.
+func notifyWaitlistOffers(conn udpWriter, offers []service.WaitlistOffer) {
+	for _, offer := range offers {
+		clientAddr, err := net.ResolveUDPAddr("udp", offer.Entry.ClientAddr)
+		if err != nil {
+			continue
+		}
+		attrs := map[string]string{
+			"hold_token": offer.Hold.Token,
+			"expires_at": offer.Hold.ExpiresAt.Format(time.RFC3339),
+		}
+		message := fmt.Sprintf("Flight %d waitlist: %d seat(s) held for you", offer.Entry.FlightID, offer.Hold.Seats)
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, message, attrs)
+		conn.WriteToUDP(response, clientAddr)
+	}
+}
+
+// subscribeMonitors sends the committed state of every changed flight to
+// its monitors, whichever code path changed it. Redelivered events are
+// skipped; an event whose flight cannot be read is retried.
+func subscribeMonitors(conn *net.UDPConn, flightService service.FlightService) {
+	bus.Subscribe(events.FlightChanged, events.Deduplicate(func(event events.Event) error {
+		flight, err := flightService.GetFlightDetails(event.FlightID)
+		if errors.Is(err, service.ErrFlightNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		notifyMonitors(conn, *flight, event.Change)
+		return nil
+	}, eventDedupCapacity))
+}
+
+// runOutboxDispatcher publishes committed outbox events to the bus and
+// periodically purges those delivered long ago.
+func runOutboxDispatcher(outboxService service.OutboxService) {
+	ticker := time.NewTicker(outboxDispatchInterval)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+	for range ticker.C {
+		if _, err := outboxService.Dispatch(service.DefaultDispatchBatch); err != nil {
+			fmt.Println("Error dispatching events:", err)
+		}
+		if time.Since(lastPurge) >= time.Hour {
+			lastPurge = time.Now()
+			if _, err := outboxService.Purge(lastPurge.Add(-outboxRetention)); err != nil {
+				fmt.Println("Error purging events:", err)
+			}
+		}
+	}
+}
+
+func auditEvent(event events.Event) error {
+	fmt.Println("Event:", event)
+	return nil
+}
+
+// respondSeatMap sends the seat map from fromRow on. Replies that would not
+// fit in a datagram end at a row boundary and carry the next row to ask for.
+func respondSeatMap(conn udpWriter, clientAddr *net.UDPAddr, seatService service.SeatService, flightID, fromRow, toRow int) {
+	seats, err := seatService.SeatMap(flightID, fromRow, toRow)
+	if err != nil {
+		response, _ := utility.SerializeSeatMap(nil, 15, 1, err.Error(), nil)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+
+	// Replies may only end where a row ends
+	var cuts []int
+	for i := 1; i <= len(seats); i++ {
+		if i == len(seats) || seats[i].Row != seats[i-1].Row {
+			cuts = append(cuts, i)
+		}
+	}
+	response, _, _ := utility.Fit(len(cuts), func(n int) ([]byte, error) {
+		if n == 0 {
+			return utility.SerializeSeatMap(nil, 15, 0, "Success", nil)
+		}
+		cut := cuts[n-1]
+		var attrs map[string]string
+		if cut < len(seats) {
+			attrs = map[string]string{"next_row": strconv.Itoa(seats[cut].Row)}
+		}
+		return utility.SerializeSeatMap(seats[:cut], 15, 0, "Success", attrs)
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondReserveSeatNumbers(conn udpWriter, clientAddr *net.UDPAddr, seatService service.SeatService, pointsService service.PointsService, flightID int, numbers []string) {
+	bookings, flight, seats, err := seatService.ReserveSeatNumbers(clientAddr.String(), flightID, numbers)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 16, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	for _, booking := range bookings {
+		_, earnRate, _ := flight.Fare(booking.FareClass)
+		earnPoints(pointsService, clientAddr, booking.TotalPrice.Scale(earnRate), booking.ID, fmt.Sprintf("Flight %d seat", flightID))
+	}
+
+	response, _ := utility.SerializeSeatMap(seats, 16, 0, "Seats reserved", nil)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func registerForMonitoring(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, monitorService service.MonitorService, flightID int, duration time.Duration, notifyEnd bool, condition models.MonitorCondition) {
+	attrs := map[string]string{"flight_id": strconv.Itoa(flightID), "ended": "1"}
+	flight, err := flightService.GetFlightDetails(flightID)
+	if err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	now := time.Now()
+	request := models.ClientInfo{
+		ClientAddr: clientAddr,
+		FlightID:   flightID,
+		Expiry:     now.Add(duration),
+		NotifyEnd:  notifyEnd,
+		Condition:  condition,
+	}
+	clientInfo, renewed, expired, err := monitors.Register(request, *flight, now)
+	endMonitors(conn, monitorService, expired)
+	if err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if err := monitorService.Save(models.FlightRegistration(clientInfo, monitor.FlightSubject(flightID))); err != nil {
+		fmt.Println("Error saving monitor:", err)
+	}
+	if renewed {
+		fmt.Println("Renewed register for monitoring: ", clientInfo)
+		return
+	}
 	fmt.Println("New register for monitoring: ", clientInfo)
-	monitors[flightID] = append(monitors[flightID], clientInfo)
 }
 
-func respondQueryPoints(conn *net.UDPConn, clientAddr *net.UDPAddr, pointsService service.PointsService) {
+// runMonitorSweeper periodically drops expired monitor registrations,
+// telling the clients that asked to be told.
+func runMonitorSweeper(conn *net.UDPConn, monitorService service.MonitorService) {
+	ticker := time.NewTicker(monitorSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		endMonitors(conn, monitorService, monitors.Sweep(now))
+		// Also clears stored rows whose registration never made it into memory
+		if err := monitorService.DeleteExpired(now); err != nil {
+			fmt.Println("Error deleting expired monitors:", err)
+		}
+	}
+}
+
+// endMonitors finishes off registrations removed from the registry: their
+// deliveries are forgotten, their stored rows deleted and clients that asked
+// are told monitoring has ended.
+func endMonitors(conn udpWriter, monitorService service.MonitorService, expired monitor.Expired) {
+	for _, client := range expired.Flights {
+		subject := monitor.FlightSubject(client.FlightID)
+		deliveries.Forget(client.ClientAddr, subject)
+		if err := monitorService.Delete(client.ClientAddr.String(), subject); err != nil {
+			fmt.Println("Error deleting monitor:", err)
+		}
+		if !client.NotifyEnd {
+			continue
+		}
+		attrs := map[string]string{"flight_id": strconv.Itoa(client.FlightID), "ended": "1"}
+		message := fmt.Sprintf("Monitoring of flight %d ended", client.FlightID)
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, message, attrs)
+		conn.WriteToUDP(response, client.ClientAddr)
+	}
+	for _, route := range expired.Routes {
+		subject := monitor.RouteSubject(route.Source, route.Destination)
+		deliveries.Forget(route.ClientAddr, subject)
+		if err := monitorService.Delete(route.ClientAddr.String(), subject); err != nil {
+			fmt.Println("Error deleting monitor:", err)
+		}
+		if !route.NotifyEnd {
+			continue
+		}
+		attrs := routeAttributes(route.Source, route.Destination)
+		attrs["ended"] = "1"
+		message := fmt.Sprintf("Monitoring of %s -> %s ended", route.Source, route.Destination)
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, message, attrs)
+		conn.WriteToUDP(response, route.ClientAddr)
+	}
+}
+
+func respondCancelMonitor(conn udpWriter, clientAddr *net.UDPAddr, monitorService service.MonitorService, flightID int) {
+	attrs := map[string]string{"flight_id": strconv.Itoa(flightID)}
+	subject := monitor.FlightSubject(flightID)
+	deliveries.Forget(clientAddr, subject)
+	if err := monitorService.Delete(clientAddr.String(), subject); err != nil {
+		fmt.Println("Error deleting monitor:", err)
+	}
+	if err := monitors.Cancel(flightID, clientAddr); err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 22, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 22, 0, fmt.Sprintf("Stopped monitoring flight %d", flightID), attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondRenewMonitor(conn udpWriter, clientAddr *net.UDPAddr, monitorService service.MonitorService, flightID int, duration time.Duration) {
+	clientInfo, err := monitors.Renew(flightID, clientAddr, duration, time.Now())
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 23, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	if err := monitorService.Save(models.FlightRegistration(clientInfo, monitor.FlightSubject(flightID))); err != nil {
+		fmt.Println("Error saving monitor:", err)
+	}
+	attrs := map[string]string{
+		"flight_id":  strconv.Itoa(flightID),
+		"expires_at": clientInfo.Expiry.Format(time.RFC3339),
+	}
+	message := fmt.Sprintf("Monitoring flight %d until %s", flightID, clientInfo.Expiry.Format(time.RFC3339))
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 23, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondListMonitors(conn udpWriter, clientAddr *net.UDPAddr) {
+	now := time.Now()
+	infos := monitors.List(clientAddr, now)
+	routes := monitors.ListRoutes(clientAddr, now)
+	if len(infos) == 0 && len(routes) == 0 {
+		response, _ := utility.SerializeMonitors(nil, nil, 24, 0, "Not monitoring any flights")
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	total := len(infos) + len(routes)
+	response, _, _ := utility.Fit(total, func(n int) ([]byte, error) {
+		flights := min(n, len(infos))
+		message := "Success"
+		if n < total {
+			message = fmt.Sprintf("Showing %d of %d monitors", n, total)
+		}
+		return utility.SerializeMonitors(infos[:flights], routes[:n-flights], 24, 0, message)
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondQueryPoints(conn udpWriter, clientAddr *net.UDPAddr, pointsService service.PointsService) {
 	// Query points using the client address as a string
 	points, err := pointsService.QueryPoints(clientAddr.String())
 	if err != nil {
@@ -197,23 +1052,627 @@ func respondQueryPoints(conn *net.UDPConn, clientAddr *net.UDPAddr, pointsServic
 		return
 	}
 
-	// Format the response message
-	response, _ := utility.SerializeFlights([]models.Flight{}, 5, 0, fmt.Sprintf("%.2f", points.Points))
+	// Format the response message, with loyalty status as attributes
+	attrs := map[string]string{}
+	if status, err := pointsService.TierStatus(clientAddr.String()); err == nil {
+		attrs["tier"] = status.Tier.Name
+		attrs["tier_points"] = status.QualifyingPoints.String()
+		attrs["tier_segments"] = strconv.Itoa(status.Segments)
+		if status.Next != nil {
+			attrs["next_tier"] = status.Next.Name
+			attrs["next_tier_points"] = status.Next.MinPoints.String()
+			attrs["next_tier_segments"] = strconv.Itoa(status.Next.MinSegments)
+		}
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 5, 0, points.Points.String(), attrs)
 	conn.WriteToUDP([]byte(response), clientAddr)
 }
 
-func notifyMonitors(conn *net.UDPConn, flightID int, seats int) {
-	clients := monitors[flightID]
-	for i, client := range clients {
-		if time.Now().Before(client.Expiry) {
-			message := fmt.Sprintf("Flight %d seat update: %d", flightID, seats)
-			response, _ := utility.SerializeFlights([]models.Flight{}, 4, 0, message)
-			conn.WriteToUDP(response, client.ClientAddr)
-		} else if i >= 0 && i < len(clients)-1 {
-			clients = append(clients[:i], clients[i+1:]...)
-		} else if i == len(clients)-1 {
-			clients = clients[:i]
+func respondExpiringPoints(conn udpWriter, clientAddr *net.UDPAddr, pointsService service.PointsService, within time.Duration) {
+	lots, total, err := pointsService.ExpiringPoints(clientAddr.String(), within)
+	if err != nil {
+		response, _ := utility.SerializePointsLots(nil, 19, 1, err.Error(), nil)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _, _ := utility.Fit(len(lots), func(n int) ([]byte, error) {
+		attrs := map[string]string{"total": total.String()}
+		if n < len(lots) {
+			attrs["shown"] = strconv.Itoa(n) // Total still covers every lot
 		}
+		return utility.SerializePointsLots(lots[:n], 19, 0, total.String()+" points expiring soon", attrs)
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondTransferPoints(conn udpWriter, clientAddr *net.UDPAddr, pointsService service.PointsService, recipient string, amount models.Money, idempotencyKey string) {
+	transfer, balance, err := pointsService.Transfer(clientAddr.String(), recipient, amount, idempotencyKey)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 20, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{
+		"transfer_id": strconv.FormatUint(uint64(transfer.ID), 10),
+		"balance":     balance.Points.String(),
+	}
+	message := fmt.Sprintf("Transferred %s points to %s", transfer.Amount, transfer.Recipient)
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 20, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// runPointsExpiry periodically expires points past their expiry date.
+func runPointsExpiry(pointsService service.PointsService) {
+	ticker := time.NewTicker(pointsExpiryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := pointsService.ExpirePoints()
+		if err != nil {
+			fmt.Println("Error expiring points:", err)
+		}
+		for _, entry := range expired {
+			fmt.Println("Expired", (-entry.Amount).String(), "points for", entry.ClientAddr)
+		}
+	}
+}
+
+// runTierReview periodically requalifies every client, downgrading those
+// whose activity has dropped out of the qualifying window.
+func runTierReview(pointsService service.PointsService) {
+	ticker := time.NewTicker(tierReviewInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := pointsService.RequalifyAll()
+		if err != nil {
+			fmt.Println("Error reviewing loyalty tiers:", err)
+		}
+		if changed > 0 {
+			fmt.Println("Loyalty tiers changed for", changed, "clients")
+		}
+	}
+}
+
+func respondPointsStatement(conn udpWriter, clientAddr *net.UDPAddr, pointsService service.PointsService, cursor string, pageSize int) {
+	statement, err := pointsService.Statement(clientAddr.String(), cursor, pageSize)
+	if err != nil {
+		response, _ := utility.SerializeStatement(models.PointsStatement{}, 18, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _, _ := utility.Fit(len(statement.Entries), func(n int) ([]byte, error) {
+		trimmed := statement
+		trimmed.Entries = statement.Entries[:n]
+		if n < len(statement.Entries) {
+			trimmed.NextCursor = service.EncodeCursor(statement.Offset + n)
+		}
+		return utility.SerializeStatement(trimmed, 18, 0, "Success")
+	})
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// notifyMonitors sends a flight's current state to the monitors whose
+// conditions the update meets, with the change that caused it, the
+// monitor's next sequence number and a timestamp as attributes. Callbacks
+// are resent until acknowledged.
+func notifyMonitors(conn *net.UDPConn, flight models.Flight, change string) {
+	now := time.Now()
+	for _, client := range monitors.Notify(flight, now) {
+		response := callbackPayload(flight, change, client.Sequence, now)
+		conn.WriteToUDP(response, client.ClientAddr)
+		deliveries.Track(client.ClientAddr, monitor.FlightSubject(flight.ID), client.Sequence, response, now)
+	}
+}
+
+// respondResync sends a monitoring client the flight's current state as a
+// callback carrying the latest sequence number, so it can recover from a
+// gap. Replies go over the callback channel, like the updates they replace.
+func respondResync(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, flightID int) {
+	attrs := map[string]string{"flight_id": strconv.Itoa(flightID)}
+	clientInfo, ok := monitors.Registration(flightID, clientAddr, time.Now())
+	if !ok {
+		attrs["ended"] = "1"
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, "Not monitoring this flight", attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	flight, err := flightService.GetFlightDetails(flightID)
+	if err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	now := time.Now()
+	sequence := clientInfo.Sequence
+	response := callbackPayload(*flight, models.ChangeResync, sequence, now)
+	conn.WriteToUDP(response, clientAddr)
+	deliveries.Track(clientAddr, monitor.FlightSubject(flightID), sequence, response, now)
+}
+
+func callbackPayload(flight models.Flight, change string, sequence uint64, now time.Time) []byte {
+	message := fmt.Sprintf("Flight %d seat update: %d", flight.ID, flight.SeatAvailability)
+	attrs := map[string]string{
+		"flight_id": strconv.Itoa(flight.ID),
+		"change":    change,
+		"seq":       strconv.FormatUint(sequence, 10),
+		"timestamp": now.Format(time.RFC3339Nano),
+		"epoch":     serverEpoch,
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{flight}, 4, 0, message, attrs)
+	return response
+}
+
+// runCallbackRetransmitter resends unacknowledged callbacks with backoff.
+func runCallbackRetransmitter(conn *net.UDPConn) {
+	ticker := time.NewTicker(retransmitInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, delivery := range deliveries.Due(time.Now()) {
+			conn.WriteToUDP(delivery.Payload, delivery.ClientAddr)
+		}
+	}
+}
+
+func registerRouteMonitor(conn udpWriter, clientAddr *net.UDPAddr, flightService service.FlightService, airportService service.AirportService, monitorService service.MonitorService, request models.RouteMonitor, duration time.Duration) {
+	attrs := routeAttributes(request.Source, request.Destination)
+	if request.Source == "" || request.Destination == "" {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 27, 1, "Source and destination are required", attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	for _, place := range []string{request.Source, request.Destination} {
+		known, err := airportService.Known(place)
+		if err == nil && !known {
+			err = errors.New("Unknown airport: " + place)
+		}
+		if err != nil {
+			response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 27, 1, err.Error(), attrs)
+			conn.WriteToUDP(response, clientAddr)
+			return
+		}
+	}
+	flights, err := flightService.QueryFlights(request.Source, request.Destination)
+	if err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 27, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	now := time.Now()
+	request.Expiry = now.Add(duration)
+	info, renewed, expired, err := monitors.RegisterRoute(request, now)
+	endMonitors(conn, monitorService, expired)
+	if err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 27, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	routeWatcher.Seed(monitor.Route{Source: info.Source, Destination: info.Destination}, flights)
+	if err := monitorService.Save(models.RouteRegistration(info, monitor.RouteSubject(info.Source, info.Destination))); err != nil {
+		fmt.Println("Error saving monitor:", err)
+	}
+	attrs["expires_at"] = info.Expiry.Format(time.RFC3339)
+	message := fmt.Sprintf("Monitoring %s -> %s until %s", info.Source, info.Destination, info.Expiry.Format(time.RFC3339))
+	if renewed {
+		message = fmt.Sprintf("Updated monitor for %s -> %s until %s", info.Source, info.Destination, info.Expiry.Format(time.RFC3339))
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 27, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondCancelRouteMonitor(conn udpWriter, clientAddr *net.UDPAddr, monitorService service.MonitorService, source, destination string) {
+	attrs := routeAttributes(source, destination)
+	subject := monitor.RouteSubject(source, destination)
+	deliveries.Forget(clientAddr, subject)
+	if err := monitorService.Delete(clientAddr.String(), subject); err != nil {
+		fmt.Println("Error deleting monitor:", err)
 	}
-	monitors[flightID] = clients
+	if err := monitors.CancelRoute(source, destination, clientAddr); err != nil {
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 22, 1, err.Error(), attrs)
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 22, 0, fmt.Sprintf("Stopped monitoring %s -> %s", source, destination), attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// subscribeRouteMonitors tells route monitors about every change made
+// through the server to a flight on their route, as it is published.
+func subscribeRouteMonitors(conn *net.UDPConn, flightService service.FlightService) {
+	bus.Subscribe(events.FlightChanged, events.Deduplicate(func(event events.Event) error {
+		flight, err := flightService.GetFlightDetails(event.FlightID)
+		if errors.Is(err, service.ErrFlightNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, route := range monitors.Routes(time.Now()) {
+			onRoute, err := flightService.OnRoute(*flight, route.Source, route.Destination)
+			if err != nil {
+				return err
+			}
+			if !onRoute {
+				continue
+			}
+			for _, change := range routeWatcher.Observe(route, *flight) {
+				notifyRouteMonitors(conn, flightService, route, change.Flight, change.Change)
+			}
+		}
+		return nil
+	}, eventDedupCapacity))
+}
+
+// runRouteWatcher polls the flights on every monitored route for changes
+// that publish no events, such as flights added, cancelled or re-timed in
+// the database directly. Changes made through the server reach monitors
+// straight away through subscribeRouteMonitors, which keeps the baselines
+// current so they are not reported twice.
+func runRouteWatcher(conn *net.UDPConn, flightService service.FlightService) {
+	ticker := time.NewTicker(routeWatchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		routes := monitors.Routes(time.Now())
+		routeWatcher.Retain(routes)
+		for _, route := range routes {
+			flights, err := flightService.QueryFlights(route.Source, route.Destination)
+			if err != nil {
+				fmt.Println("Error polling route:", err)
+				continue
+			}
+			for _, change := range routeWatcher.Diff(route, flights) {
+				notifyRouteMonitors(conn, flightService, route, change.Flight, change.Change)
+			}
+		}
+	}
+}
+
+// notifyRouteMonitors sends a changed flight to the route's monitors whose
+// window covers it. Callbacks carry the route as well as the flight and are
+// resent until acknowledged, like flight callbacks.
+func notifyRouteMonitors(conn *net.UDPConn, flightService service.FlightService, route monitor.Route, flight models.Flight, change string) {
+	loc, err := flightService.DepartureZone(flight)
+	if err != nil {
+		fmt.Println("Error reading departure zone:", err)
+		return
+	}
+	now := time.Now()
+	for _, client := range monitors.NotifyRoute(route, flight, loc, now) {
+		message := fmt.Sprintf("Flight %d on %s -> %s %s", flight.ID, client.Source, client.Destination, change)
+		attrs := routeAttributes(client.Source, client.Destination)
+		attrs["flight_id"] = strconv.Itoa(flight.ID)
+		attrs["change"] = change
+		attrs["seq"] = strconv.FormatUint(client.Sequence, 10)
+		attrs["timestamp"] = now.Format(time.RFC3339Nano)
+		attrs["epoch"] = serverEpoch
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{flight}, 4, 0, message, attrs)
+		conn.WriteToUDP(response, client.ClientAddr)
+		deliveries.Track(client.ClientAddr, monitor.RouteSubject(client.Source, client.Destination), client.Sequence, response, now)
+	}
+}
+
+func routeAttributes(source, destination string) map[string]string {
+	return map[string]string{"source": source, "destination": destination}
+}
+
+// seedAirports fills in missing airports from the CSV file named by
+// AIRPORTS_FILE, or from the built-in list when it is not set.
+func seedAirports(db *gorm.DB) {
+	airports := models.DefaultAirports
+	if path := os.Getenv("AIRPORTS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal("Failed to open airports file:", err)
+		}
+		airports, err = service.ReadAirports(file)
+		file.Close()
+		if err != nil {
+			log.Fatal("Failed to read airports file:", err)
+		}
+	}
+	added, err := service.SeedAirports(db, airports)
+	if err != nil {
+		fmt.Println("Error seeding airports:", err)
+		return
+	}
+	if added > 0 {
+		fmt.Printf("Added %d airports\n", added)
+	}
+}
+
+// auditFlightAirports warns about stored flights whose source or destination
+// is missing from the airports table. Their times are read in the server's
+// zone and searches by city or alias will not find them.
+func auditFlightAirports(airportService service.AirportService) {
+	problems, err := airportService.AuditFlights()
+	if err != nil {
+		fmt.Println("Error auditing flights:", err)
+		return
+	}
+	ids := make([]int, 0, len(problems))
+	for id := range problems {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Printf("Warning: flight %d: %v\n", id, problems[id])
+	}
+}
+
+// restoreMonitors reloads the registrations stored before the server last
+// stopped. Those that expired meanwhile or can no longer be restored are
+// deleted, and their clients told the monitor has ended.
+func restoreMonitors(conn *net.UDPConn, monitorService service.MonitorService, flightService service.FlightService) {
+	registrations, err := monitorService.Load()
+	if err != nil {
+		fmt.Println("Error loading monitors:", err)
+		return
+	}
+	now := time.Now()
+	restored := 0
+	for _, registration := range registrations {
+		var err error
+		if registration.Kind == models.MonitorRoute {
+			err = restoreRouteMonitor(conn, monitorService, flightService, registration, now)
+		} else {
+			err = restoreFlightMonitor(conn, monitorService, flightService, registration, now)
+		}
+		if err != nil {
+			fmt.Println("Error restoring monitor:", err)
+		}
+		if err != nil || !now.Before(registration.Expiry) {
+			monitorService.Delete(registration.ClientAddr, registration.Subject)
+			continue
+		}
+		restored++
+	}
+	fmt.Printf("Restored %d monitor(s)\n", restored)
+}
+
+func restoreFlightMonitor(conn *net.UDPConn, monitorService service.MonitorService, flightService service.FlightService, registration models.MonitorRegistration, now time.Time) error {
+	info, err := registration.ClientInfo()
+	if err != nil {
+		return err
+	}
+	attrs := map[string]string{"flight_id": strconv.Itoa(info.FlightID), "ended": "1"}
+	if !now.Before(info.Expiry) {
+		if info.NotifyEnd {
+			response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, fmt.Sprintf("Monitoring of flight %d ended", info.FlightID), attrs)
+			conn.WriteToUDP(response, info.ClientAddr)
+		}
+		return nil
+	}
+	flight, err := flightService.GetFlightDetails(info.FlightID)
+	if err == nil {
+		var expired monitor.Expired
+		_, _, expired, err = monitors.Register(info, *flight, now)
+		endMonitors(conn, monitorService, expired)
+	}
+	if err != nil {
+		message := fmt.Sprintf("Monitoring of flight %d was not restored: %s", info.FlightID, err.Error())
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, message, attrs)
+		conn.WriteToUDP(response, info.ClientAddr)
+	}
+	return err
+}
+
+func restoreRouteMonitor(conn *net.UDPConn, monitorService service.MonitorService, flightService service.FlightService, registration models.MonitorRegistration, now time.Time) error {
+	request, err := registration.RouteMonitor()
+	if err != nil {
+		return err
+	}
+	attrs := routeAttributes(request.Source, request.Destination)
+	attrs["ended"] = "1"
+	if !now.Before(request.Expiry) {
+		if request.NotifyEnd {
+			message := fmt.Sprintf("Monitoring of %s -> %s ended", request.Source, request.Destination)
+			response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, message, attrs)
+			conn.WriteToUDP(response, request.ClientAddr)
+		}
+		return nil
+	}
+	flights, err := flightService.QueryFlights(request.Source, request.Destination)
+	if err == nil {
+		var expired monitor.Expired
+		_, _, expired, err = monitors.RegisterRoute(request, now)
+		endMonitors(conn, monitorService, expired)
+	}
+	if err != nil {
+		message := fmt.Sprintf("Monitoring of %s -> %s was not restored: %s", request.Source, request.Destination, err.Error())
+		response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 1, message, attrs)
+		conn.WriteToUDP(response, request.ClientAddr)
+		return err
+	}
+	routeWatcher.Seed(monitor.Route{Source: request.Source, Destination: request.Destination}, flights)
+	return nil
+}
+
+// runHeartbeat tells every monitoring client that the server is alive,
+// which epoch it is in and what it is monitoring for them. Clients can then
+// tell a quiet flight from a dead server, and re-register anything lost.
+func runHeartbeat(conn *net.UDPConn) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		for _, clientAddr := range monitors.Subscribers(now) {
+			attrs := heartbeatAttributes(clientAddr, now)
+			attrs["heartbeat"] = "1"
+			response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 4, 0, "Heartbeat", attrs)
+			conn.WriteToUDP(response, clientAddr)
+		}
+	}
+}
+
+func respondPing(conn udpWriter, clientAddr *net.UDPAddr) {
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 28, 0, "Pong", heartbeatAttributes(clientAddr, time.Now()))
+	conn.WriteToUDP(response, clientAddr)
+}
+
+// heartbeatAttributes lists the flights and routes monitored for a client,
+// routes as "source|destination", alongside the server epoch. Lists too long
+// for one attribute continue in "flights.2", "routes.2" and so on; partial
+// is set when some entries did not fit in the datagram at all.
+func heartbeatAttributes(clientAddr *net.UDPAddr, now time.Time) map[string]string {
+	var flights, routes []string
+	for _, info := range monitors.List(clientAddr, now) {
+		flights = append(flights, strconv.Itoa(info.FlightID))
+	}
+	for _, route := range monitors.ListRoutes(clientAddr, now) {
+		routes = append(routes, route.Source+"|"+route.Destination)
+	}
+	attrs := map[string]string{
+		"epoch":    serverEpoch,
+		"interval": strconv.Itoa(int(heartbeatInterval / time.Second)),
+	}
+	budget := heartbeatListBudget
+	budget, flightsComplete := splitAttribute(attrs, "flights", flights, budget)
+	_, routesComplete := splitAttribute(attrs, "routes", routes, budget)
+	if !flightsComplete || !routesComplete {
+		attrs["partial"] = "1"
+	}
+	return attrs
+}
+
+// splitAttribute stores items joined by commas under key, then key.2, key.3
+// and so on, keeping each value within the 255 bytes an encoded string can
+// hold. Attributes are charged against budget bytes; items that do not fit
+// are left out. It returns the budget left and whether every item fitted.
+func splitAttribute(attrs map[string]string, key string, items []string, budget int) (int, bool) {
+	const maxValue = 255
+	complete := true
+	part, value := 1, ""
+	flush := func() {
+		name := key
+		if part > 1 {
+			name = key + "." + strconv.Itoa(part)
+		}
+		attrs[name] = value
+		budget -= len(name) + len(value) + 2
+		part, value = part+1, ""
+	}
+	for _, item := range items {
+		if len(item) > maxValue {
+			complete = false
+			continue
+		}
+		if value != "" && len(value)+1+len(item) > maxValue {
+			flush()
+		}
+		cost := len(key) + 4 + len(value) + 1 + len(item)
+		if cost > budget {
+			complete = false
+			continue
+		}
+		if value != "" {
+			value += ","
+		}
+		value += item
+	}
+	flush()
+	return budget, complete
+}
+
+// subscribeWebhooks queues every event for the webhook endpoints
+// subscribed to its type. Queueing is idempotent per event, so a failure
+// is left for the outbox to deliver again.
+func subscribeWebhooks(webhookService service.WebhookService) {
+	bus.SubscribeAll(func(event events.Event) error {
+		return webhookService.Enqueue(event)
+	})
+}
+
+// runWebhookDelivery sends due webhook deliveries, retrying failures with
+// backoff until they are dead. Each endpoint is sent to by its own worker,
+// started on a tick when it has deliveries due and no worker running, so a
+// slow endpoint never delays the others.
+func runWebhookDelivery(webhookService service.WebhookService) {
+	ticker := time.NewTicker(webhookDeliveryInterval)
+	defer ticker.Stop()
+	busy := make(map[uint]bool)
+	done := make(chan uint)
+	for {
+		select {
+		case endpointID := <-done:
+			delete(busy, endpointID)
+		case <-ticker.C:
+			endpointIDs, err := webhookService.DueEndpoints()
+			if err != nil {
+				fmt.Println("Error finding due webhooks:", err)
+				continue
+			}
+			for _, endpointID := range endpointIDs {
+				if busy[endpointID] {
+					continue
+				}
+				busy[endpointID] = true
+				go func(endpointID uint) {
+					defer func() { done <- endpointID }()
+					delivered, failed, err := webhookService.DeliverTo(endpointID)
+					if err != nil {
+						fmt.Printf("Error delivering webhooks to endpoint %d: %v\n", endpointID, err)
+					}
+					if delivered > 0 || failed > 0 {
+						fmt.Printf("Webhooks to endpoint %d: %d delivered, %d failed\n", endpointID, delivered, failed)
+					}
+				}(endpointID)
+			}
+		}
+	}
+}
+
+// operatorOnly refuses operator requests that do not come from this host.
+func operatorOnly(conn udpWriter, clientAddr *net.UDPAddr, opcode byte) bool {
+	if clientAddr.IP.IsLoopback() {
+		return true
+	}
+	response, _ := utility.SerializeFlights([]models.Flight{}, opcode, 1, "Operator requests are only accepted from this host")
+	conn.WriteToUDP(response, clientAddr)
+	return false
+}
+
+func respondRegisterWebhook(conn udpWriter, clientAddr *net.UDPAddr, webhookService service.WebhookService, endpointURL, eventType string) {
+	if !operatorOnly(conn, clientAddr, 29) {
+		return
+	}
+	endpoint, err := webhookService.Register(endpointURL, eventType)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 29, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{
+		"endpoint_id": strconv.FormatUint(uint64(endpoint.ID), 10),
+		"event_type":  endpoint.EventType,
+		"secret":      endpoint.Secret,
+	}
+	message := fmt.Sprintf("Webhook %d registered for %s", endpoint.ID, endpoint.EventType)
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 29, 0, message, attrs)
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondGenerateSeatMap(conn udpWriter, clientAddr *net.UDPAddr, seatService service.SeatService, flightID int, layout models.SeatLayout) {
+	if !operatorOnly(conn, clientAddr, 32) {
+		return
+	}
+	if err := seatService.GenerateSeatMap(flightID, layout); err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 32, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	response, _ := utility.SerializeFlights([]models.Flight{}, 32, 0, fmt.Sprintf("Seat map created for flight %d", flightID))
+	conn.WriteToUDP(response, clientAddr)
+}
+
+func respondReplayWebhooks(conn udpWriter, clientAddr *net.UDPAddr, webhookService service.WebhookService, deliveryID uint) {
+	if !operatorOnly(conn, clientAddr, 30) {
+		return
+	}
+	count, err := webhookService.Replay(deliveryID)
+	if err != nil {
+		response, _ := utility.SerializeFlights([]models.Flight{}, 30, 1, err.Error())
+		conn.WriteToUDP(response, clientAddr)
+		return
+	}
+	attrs := map[string]string{"replayed": strconv.FormatInt(count, 10)}
+	response, _ := utility.SerializeFlightsWithAttributes([]models.Flight{}, 30, 0, fmt.Sprintf("%d delivery(ies) queued again", count), attrs)
+	conn.WriteToUDP(response, clientAddr)
 }
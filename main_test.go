@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitAttributeSplitsLongLists(t *testing.T) {
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf("route-%03d", i))
+	}
+	attrs := map[string]string{}
+	left, complete := splitAttribute(attrs, "routes", items, 10000)
+	if !complete {
+		t.Fatal("list reported incomplete with budget to spare")
+	}
+	var parts []string
+	used := 0
+	for part := 1; ; part++ {
+		name := "routes"
+		if part > 1 {
+			name = fmt.Sprintf("routes.%d", part)
+		}
+		value, ok := attrs[name]
+		if !ok {
+			break
+		}
+		if len(value) > 255 {
+			t.Fatalf("%s holds %d bytes, more than an encoded string can", name, len(value))
+		}
+		parts = append(parts, value)
+		used += len(name) + len(value) + 2
+	}
+	if len(parts) != 4 || len(attrs) != 4 {
+		t.Fatalf("split into %d parts (%d attributes), want 4", len(parts), len(attrs))
+	}
+	if got := strings.Join(parts, ","); got != strings.Join(items, ",") {
+		t.Fatalf("parts rejoin to %q", got)
+	}
+	if left != 10000-used {
+		t.Fatalf("budget left = %d, want %d", left, 10000-used)
+	}
+}
+
+func TestSplitAttributeStopsAtBudget(t *testing.T) {
+	items := []string{"route-001", "route-002", "route-003", "route-004", "route-005"}
+	attrs := map[string]string{}
+	left, complete := splitAttribute(attrs, "routes", items, 50)
+	if complete {
+		t.Fatal("list reported complete after running out of budget")
+	}
+	if want := strings.Join(items[:4], ","); attrs["routes"] != want {
+		t.Fatalf("routes = %q, want %q", attrs["routes"], want)
+	}
+	if want := 50 - len("routes") - len(attrs["routes"]) - 2; left != want {
+		t.Fatalf("budget left = %d, want %d", left, want)
+	}
+}
+
+func TestSplitAttributeSkipsOversizedItems(t *testing.T) {
+	attrs := map[string]string{}
+	_, complete := splitAttribute(attrs, "flights", []string{"1", strings.Repeat("x", 300), "2"}, 1000)
+	if complete || attrs["flights"] != "1,2" {
+		t.Fatalf("flights = %q, complete %v; want \"1,2\" and incomplete", attrs["flights"], complete)
+	}
+}
@@ -6,29 +6,67 @@ import (
 )
 
 type Flight struct {
-	ID               int     `gorm:"primaryKey"`
-	Source           string  `gorm:"size:100;not null"`
-	Destination      string  `gorm:"size:100;not null"`
-	DepartureTime    string  `gorm:"size:20;not null"` // You can customize this to your preferred time format.
-	Airfare          float64 `gorm:"not null"`
-	SeatAvailability int     `gorm:"not null"`
+	ID               int         `gorm:"primaryKey"`
+	Source           string      `gorm:"size:100;not null"`
+	Destination      string      `gorm:"size:100;not null"`
+	DepartureTime    string      `gorm:"size:20;not null"` // You can customize this to your preferred time format.
+	ArrivalTime      string      `gorm:"size:20"`
+	Airfare          Money       `gorm:"type:bigint;not null"` // Minor units
+	SeatAvailability int         `gorm:"not null"`
+	Capacity         int         `gorm:"not null;default:0"` // Zero when unknown
+	Carrier          string      `gorm:"size:50"`
+	FareClasses      []FareClass `gorm:"foreignKey:FlightID"`
 }
 
 type RequestFlight struct {
-	ID            int
-	Source        string
-	Destination   string
-	DepartureTime string
-	SeattoBook    int
-	Duration      time.Duration
+	ID             int
+	Source         string
+	Destination    string
+	DepartureTime  string
+	SeattoBook     int
+	Duration       time.Duration
+	MaxStops       int
+	MinLayover     time.Duration
+	MaxLayover     time.Duration
+	SortBy         string
+	FlightIDs      []int // Legs of an itinerary, in travel order
+	MinPrice       Money
+	MaxPrice       Money
+	MinSeats       int
+	DepartAfter    string
+	DepartBefore   string
+	Carrier        string
+	Cursor         string
+	PageSize       int
+	HoldToken      string
+	FareClass      string
+	SeatNumbers    []string
+	FromRow        int
+	ToRow          int
+	QuoteID        string
+	Recipient      string
+	Points         Money
+	IdempotencyKey string
+	NotifyEnd      bool
+	Sequence       uint64 // Monitor callback being acknowledged
+	Condition      MonitorCondition
+	WebhookURL     string
+	EventType      string
+	SeatLayout     SeatLayout // Aircraft layout for a new seat map
 }
 
 type ClientInfo struct {
 	ClientAddr *net.UDPAddr
+	FlightID   int
 	Expiry     time.Time
+	NotifyEnd  bool // Send a message when the registration expires
+	Condition  MonitorCondition
+	Sequence   uint64          // Last callback sequence number sent to this client
+	Last       MonitorSnapshot // Flight state when last evaluated
 }
 
 type ClientPoints struct {
-	ClientAddr string  `gorm:"primaryKey;type:varchar(255)"` // Use string to store the UDP address
-	Points     float64 `gorm:"type:double"`                  // Store points as a double
+	ClientAddr string `gorm:"primaryKey;type:varchar(255)"`   // Use string to store the UDP address
+	Points     Money  `gorm:"type:bigint;not null;default:0"` // Minor units, like fares
+	Tier       string `gorm:"size:20"`                        // Loyalty tier name
 }
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type Airport struct {
+	Code     string `gorm:"primaryKey;type:varchar(3)"` // IATA code, e.g. SIN
+	Name     string `gorm:"size:100;not null"`
+	City     string `gorm:"size:100;not null;index"`
+	Country  string `gorm:"size:100"`
+	Aliases  string `gorm:"size:255"` // Comma-separated alternative names, e.g. "Changi"
+	TimeZone string `gorm:"size:64"`  // IANA time zone, e.g. Asia/Singapore
+}
+
+// Names returns every lowercase name the airport is known by.
+func (a Airport) Names() []string {
+	names := []string{strings.ToLower(a.Code), strings.ToLower(a.Name), strings.ToLower(a.City)}
+	for _, alias := range strings.Split(a.Aliases, ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			names = append(names, strings.ToLower(alias))
+		}
+	}
+	return names
+}
+
+// Location returns the airport's time zone, or the server's own zone when
+// none is set or it is not a known IANA name.
+func (a Airport) Location() *time.Location {
+	if a.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(a.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// DefaultAirports seeds the airports table on a fresh database.
+var DefaultAirports = []Airport{
+	{Code: "SIN", Name: "Singapore Changi Airport", City: "Singapore", Country: "Singapore", Aliases: "Changi", TimeZone: "Asia/Singapore"},
+	{Code: "KUL", Name: "Kuala Lumpur International Airport", City: "Kuala Lumpur", Country: "Malaysia", Aliases: "KLIA", TimeZone: "Asia/Kuala_Lumpur"},
+	{Code: "BKK", Name: "Suvarnabhumi Airport", City: "Bangkok", Country: "Thailand", Aliases: "Suvarnabhumi", TimeZone: "Asia/Bangkok"},
+	{Code: "CGK", Name: "Soekarno-Hatta International Airport", City: "Jakarta", Country: "Indonesia", Aliases: "Soekarno-Hatta", TimeZone: "Asia/Jakarta"},
+	{Code: "MNL", Name: "Ninoy Aquino International Airport", City: "Manila", Country: "Philippines", Aliases: "NAIA", TimeZone: "Asia/Manila"},
+	{Code: "HKG", Name: "Hong Kong International Airport", City: "Hong Kong", Country: "China", Aliases: "Chek Lap Kok", TimeZone: "Asia/Hong_Kong"},
+	{Code: "PEK", Name: "Beijing Capital International Airport", City: "Beijing", Country: "China", Aliases: "Beijing Capital", TimeZone: "Asia/Shanghai"},
+	{Code: "PVG", Name: "Shanghai Pudong International Airport", City: "Shanghai", Country: "China", Aliases: "Pudong", TimeZone: "Asia/Shanghai"},
+	{Code: "ICN", Name: "Incheon International Airport", City: "Seoul", Country: "South Korea", Aliases: "Incheon", TimeZone: "Asia/Seoul"},
+	{Code: "NRT", Name: "Narita International Airport", City: "Tokyo", Country: "Japan", Aliases: "Narita", TimeZone: "Asia/Tokyo"},
+	{Code: "HND", Name: "Haneda Airport", City: "Tokyo", Country: "Japan", Aliases: "Haneda", TimeZone: "Asia/Tokyo"},
+	{Code: "DEL", Name: "Indira Gandhi International Airport", City: "Delhi", Country: "India", Aliases: "New Delhi", TimeZone: "Asia/Kolkata"},
+	{Code: "DXB", Name: "Dubai International Airport", City: "Dubai", Country: "United Arab Emirates", TimeZone: "Asia/Dubai"},
+	{Code: "SYD", Name: "Sydney Kingsford Smith Airport", City: "Sydney", Country: "Australia", Aliases: "Kingsford Smith", TimeZone: "Australia/Sydney"},
+	{Code: "MEL", Name: "Melbourne Airport", City: "Melbourne", Country: "Australia", Aliases: "Tullamarine", TimeZone: "Australia/Melbourne"},
+	{Code: "LHR", Name: "London Heathrow Airport", City: "London", Country: "United Kingdom", Aliases: "Heathrow", TimeZone: "Europe/London"},
+	{Code: "CDG", Name: "Paris Charles de Gaulle Airport", City: "Paris", Country: "France", Aliases: "Charles de Gaulle,Roissy", TimeZone: "Europe/Paris"},
+	{Code: "FRA", Name: "Frankfurt Airport", City: "Frankfurt", Country: "Germany", TimeZone: "Europe/Berlin"},
+	{Code: "AMS", Name: "Amsterdam Airport Schiphol", City: "Amsterdam", Country: "Netherlands", Aliases: "Schiphol", TimeZone: "Europe/Amsterdam"},
+	{Code: "JFK", Name: "John F. Kennedy International Airport", City: "New York", Country: "United States", Aliases: "Kennedy", TimeZone: "America/New_York"},
+	{Code: "LAX", Name: "Los Angeles International Airport", City: "Los Angeles", Country: "United States", TimeZone: "America/Los_Angeles"},
+	{Code: "SFO", Name: "San Francisco International Airport", City: "San Francisco", Country: "United States", TimeZone: "America/Los_Angeles"},
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Booking records a reservation, the price actually charged for it and how
+// that price was split between cash and points.
+type Booking struct {
+	ID         uint   `gorm:"primaryKey"`
+	ClientAddr string `gorm:"type:varchar(255);not null;index"`
+	FlightID   int    `gorm:"not null;index"`
+	FareClass  string `gorm:"size:2"`
+	Seats      int    `gorm:"not null"`
+	UnitPrice  Money  `gorm:"type:bigint;not null"`
+	TotalPrice Money  `gorm:"type:bigint;not null"`
+	PaidCash   Money  `gorm:"type:bigint;not null;default:0"`
+	PaidPoints Money  `gorm:"type:bigint;not null;default:0"` // Points debited, after any tier discount
+	CreatedAt  time.Time
+}
@@ -0,0 +1,35 @@
+package models
+
+import "errors"
+
+// FareClass is a separately priced and sold cabin inventory on a flight.
+// Flight.SeatAvailability is the total across its fare classes.
+type FareClass struct {
+	ID               uint    `gorm:"primaryKey"`
+	FlightID         int     `gorm:"not null;uniqueIndex:idx_flight_fare_class"`
+	Code             string  `gorm:"size:2;not null;uniqueIndex:idx_flight_fare_class"` // e.g. Y, J
+	Name             string  `gorm:"size:50;not null"`                                  // e.g. Economy, Business
+	Fare             Money   `gorm:"type:bigint;not null"`
+	SeatAvailability int     `gorm:"not null"`
+	Capacity         int     `gorm:"not null;default:0"`
+	Refundable       bool    `gorm:"not null;default:false"`
+	EarnRate         float64 `gorm:"not null;default:1"` // Points earned per unit of fare
+}
+
+// Fare returns the price and points earn rate for a fare class. An empty
+// code books the flight's base Airfare at one point per unit of fare, and is
+// only valid on flights without fare classes.
+func (f Flight) Fare(code string) (Money, float64, error) {
+	if code == "" {
+		if len(f.FareClasses) > 0 {
+			return 0, 0, errors.New("Fare class required")
+		}
+		return f.Airfare, 1, nil
+	}
+	for _, class := range f.FareClasses {
+		if class.Code == code {
+			return class.Fare, class.EarnRate, nil
+		}
+	}
+	return 0, 0, errors.New("Fare class not found")
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	HoldActive    = "held"
+	HoldConfirmed = "confirmed"
+	HoldReleased  = "released"
+)
+
+// SeatHold takes seats out of a flight's availability until it is confirmed
+// or expires.
+type SeatHold struct {
+	Token      string    `gorm:"primaryKey;type:varchar(64)"`
+	FlightID   int       `gorm:"not null;index"`
+	ClientAddr string    `gorm:"type:varchar(255);not null"`
+	Seats      int       `gorm:"not null"`
+	FareClass  string    `gorm:"size:2"`
+	UnitPrice  Money     `gorm:"type:bigint;not null"` // Price locked when the hold was placed
+	Status     string    `gorm:"size:20;not null;index"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	CreatedAt  time.Time
+}
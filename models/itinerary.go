@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TimeLayout is the format used for DepartureTime and ArrivalTime.
+const TimeLayout = "2006-01-02 15:04"
+
+var timeLayouts = []string{TimeLayout, "2006-01-02 15:04:05", time.RFC3339}
+
+type Itinerary struct {
+	Legs          []Flight
+	TotalFare     Money
+	TotalDuration time.Duration
+}
+
+type ItineraryQuery struct {
+	Source      string
+	Destination string
+	SearchFilters
+	MaxStops   int
+	MinLayover time.Duration
+	MaxLayover time.Duration
+	SortBy     string // "price" (default), "departure" or "duration"
+	Cursor     string
+	PageSize   int
+}
+
+// ParseFlightTimeIn parses a DepartureTime or ArrivalTime value as wall-clock
+// time at loc, the zone of the airport it refers to.
+func ParseFlightTimeIn(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid flight time: " + value)
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	PointsEarn       = "earn"
+	PointsRedeem     = "redeem"
+	PointsRefund     = "refund"
+	PointsAdjustment = "adjustment"
+	PointsExpiry     = "expiry"
+	PointsTransfer   = "transfer"
+)
+
+// PointsEntry is one append-only line of a client's points ledger. Credits
+// are positive and debits negative; Balance is the balance after the entry.
+type PointsEntry struct {
+	ID          uint   `gorm:"primaryKey"`
+	ClientAddr  string `gorm:"type:varchar(255);not null;index"`
+	Type        string `gorm:"size:20;not null"`
+	Amount      Money  `gorm:"type:bigint;not null"`
+	Balance     Money  `gorm:"type:bigint;not null"`
+	BookingID   *uint  `gorm:"index"`
+	Description string `gorm:"size:255"`
+	CreatedAt   time.Time
+}
+
+type PointsStatement struct {
+	Entries       []PointsEntry
+	Balance       Money // Stored balance
+	LedgerBalance Money // Sum of every ledger entry
+	NextCursor    string
+	Offset        int // Position of the first entry in the full ledger
+}
+
+// PointsLot tracks what is left of one credit to a client's balance so it
+// can expire. Debits use up the lots that expire soonest first.
+type PointsLot struct {
+	ID         uint       `gorm:"primaryKey"`
+	ClientAddr string     `gorm:"type:varchar(255);not null;index"`
+	EntryID    uint       `gorm:"not null"` // Ledger entry that credited the points
+	Amount     Money      `gorm:"type:bigint;not null"`
+	Remaining  Money      `gorm:"type:bigint;not null"`
+	EarnedAt   time.Time  `gorm:"not null"`
+	ExpiresAt  *time.Time `gorm:"index"` // Nil when the points never expire
+}
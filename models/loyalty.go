@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// Tier is one level of the loyalty programme. A client qualifies by earning
+// MinPoints or flying MinSegments within the programme's rolling window.
+type Tier struct {
+	Name               string
+	MinPoints          Money
+	MinSegments        int
+	EarnMultiplier     float64 // Applied to points earned
+	RedemptionDiscount float64 // Share of a points price waived, e.g. 0.1
+}
+
+// LoyaltyProgram lists tiers from lowest to highest. The first tier is the
+// one every client starts in.
+type LoyaltyProgram struct {
+	Tiers  []Tier
+	Window time.Duration
+}
+
+type TierStatus struct {
+	Tier             Tier
+	QualifyingPoints Money // Points earned within the window
+	Segments         int   // Flights booked within the window
+	Next             *Tier // Nil at the top tier
+}
+
+// DefaultLoyaltyProgram returns the standard Member, Silver, Gold and
+// Platinum tiers over a one year window.
+func DefaultLoyaltyProgram() *LoyaltyProgram {
+	return &LoyaltyProgram{
+		Tiers: []Tier{
+			{Name: "Member", EarnMultiplier: 1},
+			{Name: "Silver", MinPoints: 5000 * MoneyScale, MinSegments: 10, EarnMultiplier: 1.25, RedemptionDiscount: 0.05},
+			{Name: "Gold", MinPoints: 15000 * MoneyScale, MinSegments: 25, EarnMultiplier: 1.5, RedemptionDiscount: 0.1},
+			{Name: "Platinum", MinPoints: 40000 * MoneyScale, MinSegments: 50, EarnMultiplier: 2, RedemptionDiscount: 0.2},
+		},
+		Window: 365 * 24 * time.Hour,
+	}
+}
+
+// Tier returns the named tier, or the starting tier when the name is unknown.
+// A nil or empty programme has a single tier with no benefits.
+func (p *LoyaltyProgram) Tier(name string) Tier {
+	if p == nil || len(p.Tiers) == 0 {
+		return Tier{EarnMultiplier: 1}
+	}
+	for _, tier := range p.Tiers {
+		if tier.Name == name {
+			return tier
+		}
+	}
+	return p.Tiers[0]
+}
+
+// Qualify returns the highest tier reached by the given activity.
+func (p *LoyaltyProgram) Qualify(points Money, segments int) Tier {
+	if p == nil || len(p.Tiers) == 0 {
+		return Tier{EarnMultiplier: 1}
+	}
+	reached := p.Tiers[0]
+	for _, tier := range p.Tiers {
+		if points >= tier.MinPoints || (tier.MinSegments > 0 && segments >= tier.MinSegments) {
+			reached = tier
+		}
+	}
+	return reached
+}
+
+// Next returns the tier above the named one, or nil at the top.
+func (p *LoyaltyProgram) Next(name string) *Tier {
+	if p == nil {
+		return nil
+	}
+	for i, tier := range p.Tiers {
+		if tier.Name == name && i+1 < len(p.Tiers) {
+			next := p.Tiers[i+1]
+			return &next
+		}
+	}
+	return nil
+}
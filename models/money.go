@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is a fare or points amount in minor units, so 12.50 is stored as
+// 1250. Points use the same scale as fares.
+type Money int64
+
+// MoneyScale is the number of minor units in one whole unit.
+const MoneyScale = 100
+
+// String formats the amount with two decimals, as carried on the wire.
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/MoneyScale, m%MoneyScale)
+}
+
+// Times returns the amount for n units, e.g. seats.
+func (m Money) Times(n int) Money {
+	return m * Money(n)
+}
+
+// Scale multiplies the amount by a rate, such as a pricing multiplier or a
+// points earn rate, rounding to the nearest minor unit.
+func (m Money) Scale(rate float64) Money {
+	return Money(math.Round(float64(m) * rate))
+}
+
+// ParseMoney parses a decimal amount with at most two decimals exactly.
+func ParseMoney(value string) (Money, error) {
+	invalid := errors.New("Invalid amount: " + value)
+	text := strings.TrimSpace(value)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+
+	whole, fraction, _ := strings.Cut(text, ".")
+	if whole == "" && fraction == "" || len(fraction) > 2 {
+		return 0, invalid
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units < 0 {
+		return 0, invalid
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || cents < 0 {
+		return 0, invalid
+	}
+	amount := Money(units*MoneyScale + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
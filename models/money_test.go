@@ -0,0 +1,53 @@
+package models
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Money
+	}{
+		{"12.50", 1250},
+		{"12.5", 1250},
+		{"12", 1200},
+		{".05", 5},
+		{"0.01", 1},
+		{" 7.00 ", 700},
+		{"-3.25", -325},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyRejectsInvalidAmounts(t *testing.T) {
+	for _, value := range []string{"", ".", "1.234", "abc", "1.x", "--1", "1e3"} {
+		if got, err := ParseMoney(value); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		amount Money
+		want   string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-325, "-3.25"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.amount, got, tt.want)
+		}
+		if back, err := ParseMoney(tt.amount.String()); err != nil || back != tt.amount {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.amount.String(), back, err, tt.amount)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"net"
+	"time"
+)
+
+// Kinds of persisted monitor registration.
+const (
+	MonitorFlight = "flight"
+	MonitorRoute  = "route"
+)
+
+// MonitorRegistration is a flight or route monitor as stored in the
+// database, so registrations survive a server restart. Subject identifies
+// what is monitored and is unique per client.
+type MonitorRegistration struct {
+	ID             uint      `gorm:"primaryKey"`
+	ClientAddr     string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_monitor_subject"`
+	Subject        string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_monitor_subject"`
+	Kind           string    `gorm:"size:10;not null"`
+	FlightID       int       `gorm:"index"`
+	Source         string    `gorm:"size:100"`
+	Destination    string    `gorm:"size:100"`
+	DepartAfter    string    `gorm:"size:20"`
+	DepartBefore   string    `gorm:"size:20"`
+	Expiry         time.Time `gorm:"not null;index"`
+	NotifyEnd      bool
+	SeatsBelow     int
+	SeatsAvailable bool
+	FareThreshold  Money  `gorm:"type:bigint;not null;default:0"`
+	FareClass      string `gorm:"size:2"`
+}
+
+// FlightRegistration returns the stored form of a flight monitor.
+func FlightRegistration(info ClientInfo, subject string) MonitorRegistration {
+	return MonitorRegistration{
+		ClientAddr:     info.ClientAddr.String(),
+		Subject:        subject,
+		Kind:           MonitorFlight,
+		FlightID:       info.FlightID,
+		Expiry:         info.Expiry,
+		NotifyEnd:      info.NotifyEnd,
+		SeatsBelow:     info.Condition.SeatsBelow,
+		SeatsAvailable: info.Condition.SeatsAvailable,
+		FareThreshold:  info.Condition.FareThreshold,
+		FareClass:      info.Condition.FareClass,
+	}
+}
+
+// RouteRegistration returns the stored form of a route monitor.
+func RouteRegistration(monitor RouteMonitor, subject string) MonitorRegistration {
+	return MonitorRegistration{
+		ClientAddr:   monitor.ClientAddr.String(),
+		Subject:      subject,
+		Kind:         MonitorRoute,
+		Source:       monitor.Source,
+		Destination:  monitor.Destination,
+		DepartAfter:  monitor.DepartAfter,
+		DepartBefore: monitor.DepartBefore,
+		Expiry:       monitor.Expiry,
+		NotifyEnd:    monitor.NotifyEnd,
+	}
+}
+
+// ClientInfo returns a stored flight monitor as a registration request.
+func (r MonitorRegistration) ClientInfo() (ClientInfo, error) {
+	addr, err := net.ResolveUDPAddr("udp", r.ClientAddr)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	return ClientInfo{
+		ClientAddr: addr,
+		FlightID:   r.FlightID,
+		Expiry:     r.Expiry,
+		NotifyEnd:  r.NotifyEnd,
+		Condition: MonitorCondition{
+			SeatsBelow:     r.SeatsBelow,
+			SeatsAvailable: r.SeatsAvailable,
+			FareThreshold:  r.FareThreshold,
+			FareClass:      r.FareClass,
+		},
+	}, nil
+}
+
+// RouteMonitor returns a stored route monitor as a registration request.
+func (r MonitorRegistration) RouteMonitor() (RouteMonitor, error) {
+	addr, err := net.ResolveUDPAddr("udp", r.ClientAddr)
+	if err != nil {
+		return RouteMonitor{}, err
+	}
+	return RouteMonitor{
+		ClientAddr:   addr,
+		Source:       r.Source,
+		Destination:  r.Destination,
+		DepartAfter:  r.DepartAfter,
+		DepartBefore: r.DepartBefore,
+		Expiry:       r.Expiry,
+		NotifyEnd:    r.NotifyEnd,
+	}, nil
+}
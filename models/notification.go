@@ -0,0 +1,62 @@
+package models
+
+import (
+	"net"
+	"time"
+)
+
+// Changes reported to flight monitors.
+const (
+	ChangeBooking      = "booking"
+	ChangeCancellation = "cancellation"
+	ChangePrice        = "price"
+	ChangeSchedule     = "schedule"
+	ChangeResync       = "resync" // Current state sent on request
+
+	// Route monitors are also told about these
+	ChangeFlightAdded     = "added"
+	ChangeFlightCancelled = "cancelled"
+	ChangeSeatsReturned   = "seats_returned"
+)
+
+// MonitorCondition limits which updates a monitor is sent. Conditions are
+// alternatives: an update is sent when any set condition holds. With none
+// set, every update is sent. A FareClass makes the conditions apply to that
+// class, and on its own sends only updates that change the class.
+type MonitorCondition struct {
+	SeatsBelow     int   // Seats changed while below this many
+	SeatsAvailable bool  // Seats went from none to some
+	FareThreshold  Money // Fare crossed this amount, either way
+	FareClass      string
+}
+
+// IsZero reports whether no condition is set.
+func (c MonitorCondition) IsZero() bool {
+	return c == MonitorCondition{}
+}
+
+// MonitorSnapshot is the state a monitor last saw, for detecting changes.
+type MonitorSnapshot struct {
+	Seats int
+	Fare  Money
+}
+
+// RouteMonitor subscribes a client to every flight between two locations,
+// optionally only those departing within a window. Window bounds are
+// wall-clock times at the departure airport, like the search filters.
+type RouteMonitor struct {
+	ClientAddr   *net.UDPAddr
+	Source       string
+	Destination  string
+	DepartAfter  string
+	DepartBefore string
+	Expiry       time.Time
+	NotifyEnd    bool
+	Sequence     uint64 // Last callback sequence number sent to this client
+}
+
+// Covers reports whether a flight departs within the monitor's window, with
+// its departure read in loc, the zone of its source airport.
+func (m RouteMonitor) Covers(flight Flight, loc *time.Location) bool {
+	return DepartsWithin(flight.DepartureTime, m.DepartAfter, m.DepartBefore, loc)
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OutboxEvent is a domain event written in the same transaction as the
+// change it describes, and delivered once that transaction has committed.
+// The dedup key stays the same across redeliveries. An event its subscribers
+// keep failing is given up on and marked failed, so it stops holding up the
+// events after it.
+type OutboxEvent struct {
+	ID           uint   `gorm:"primaryKey"`
+	DedupKey     string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Type         string `gorm:"size:40;not null"`
+	Payload      string `gorm:"type:text;not null"` // The event as JSON
+	CreatedAt    time.Time
+	DispatchedAt *time.Time `gorm:"index"` // Nil until delivered
+	FailedAt     *time.Time // Set when delivery was given up on
+	Attempts     int
+	LastError    string `gorm:"type:varchar(500)"`
+}
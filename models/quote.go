@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Quote guarantees a price for a number of seats until it expires. The ID
+// carries a signature over the quoted terms.
+type Quote struct {
+	ID         string    `gorm:"primaryKey;type:varchar(128)"`
+	ClientAddr string    `gorm:"type:varchar(255);not null"`
+	FlightID   int       `gorm:"not null;index"`
+	FareClass  string    `gorm:"size:2"`
+	Seats      int       `gorm:"not null"`
+	UnitPrice  Money     `gorm:"type:bigint;not null"`
+	TotalPrice Money     `gorm:"type:bigint;not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	Used       bool      `gorm:"not null;default:false"`
+	CreatedAt  time.Time
+}
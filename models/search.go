@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// SearchFilters are the optional constraints shared by flight and itinerary searches.
+// Zero values mean "no constraint". DepartAfter and DepartBefore use TimeLayout
+// and are wall-clock times at the departure airport.
+type SearchFilters struct {
+	MinPrice     Money
+	MaxPrice     Money
+	MinSeats     int
+	DepartAfter  string
+	DepartBefore string
+	Carrier      string
+}
+
+// DepartsWithin reports whether a departure time falls inside a window, with
+// the departure and both bounds read as wall-clock time at loc. Empty bounds
+// are open; a time that cannot be read falls outside any window.
+func DepartsWithin(departureTime, after, before string, loc *time.Location) bool {
+	if after == "" && before == "" {
+		return true
+	}
+	departure, err := ParseFlightTimeIn(departureTime, loc)
+	if err != nil {
+		return false
+	}
+	if after != "" {
+		bound, err := ParseFlightTimeIn(after, loc)
+		if err != nil || departure.Before(bound) {
+			return false
+		}
+	}
+	if before != "" {
+		bound, err := ParseFlightTimeIn(before, loc)
+		if err != nil || departure.After(bound) {
+			return false
+		}
+	}
+	return true
+}
+
+type FlightSearch struct {
+	Source      string
+	Destination string
+	SearchFilters
+	SortBy   string // "price" (default), "departure" or "duration"
+	Cursor   string
+	PageSize int
+}
+
+type FlightPage struct {
+	Flights    []Flight
+	NextCursor string
+	Offset     int // Position of the first flight in the full result
+}
+
+type ItineraryPage struct {
+	Itineraries []Itinerary
+	NextCursor  string
+	Offset      int // Position of the first itinerary in the full result
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepartsWithin(t *testing.T) {
+	singapore := time.FixedZone("SGT", 8*60*60)
+	tests := []struct {
+		departure     string
+		after, before string
+		want          bool
+	}{
+		{"2026-03-01 09:00", "", "", true},
+		{"2026-03-01 09:00", "2026-03-01 08:00", "2026-03-01 10:00", true},
+		{"2026-03-01 09:00", "2026-03-01 09:00", "", true},
+		{"2026-03-01 09:00", "", "2026-03-01 09:00", true},
+		{"2026-03-01 07:59", "2026-03-01 08:00", "", false},
+		{"2026-03-01 10:01", "", "2026-03-01 10:00", false},
+		{"2026-03-01 09:00:00", "2026-03-01 09:00", "", true},
+		{"not a time", "2026-03-01 08:00", "", false},
+		{"2026-03-01 09:00", "tomorrow", "", false},
+	}
+	for _, tt := range tests {
+		if got := DepartsWithin(tt.departure, tt.after, tt.before, singapore); got != tt.want {
+			t.Errorf("DepartsWithin(%q, %q, %q) = %v, want %v", tt.departure, tt.after, tt.before, got, tt.want)
+		}
+	}
+}
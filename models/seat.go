@@ -0,0 +1,85 @@
+package models
+
+import "fmt"
+
+const (
+	SeatWindow = "window"
+	SeatMiddle = "middle"
+	SeatAisle  = "aisle"
+)
+
+// Seat is one physical seat on a flight. Cabin is the fare class code the
+// seat is sold under.
+type Seat struct {
+	ID         uint   `gorm:"primaryKey"`
+	FlightID   int    `gorm:"not null;uniqueIndex:idx_flight_seat"`
+	Number     string `gorm:"size:4;not null;uniqueIndex:idx_flight_seat"` // e.g. 12A
+	Row        int    `gorm:"column:seat_row;not null"`                    // ROW is reserved in MySQL
+	Letter     string `gorm:"size:1;not null"`
+	Cabin      string `gorm:"size:2"`
+	ExitRow    bool   `gorm:"not null;default:false"`
+	Position   string `gorm:"size:10;not null"` // window, middle or aisle
+	Occupied   bool   `gorm:"not null;default:false"`
+	ClientAddr string `gorm:"type:varchar(255)"`
+	Assigned   bool   `gorm:"not null;default:false"` // Picked by the server for seats booked by count
+}
+
+// SeatLayout describes an aircraft cabin. Letters lists the seats in a row
+// from left to right with a space for each aisle, e.g. "ABC DEF".
+type SeatLayout struct {
+	Rows     int
+	Letters  string
+	Cabins   []CabinRows
+	ExitRows []int
+}
+
+// CabinRows assigns an inclusive range of rows to a fare class.
+type CabinRows struct {
+	Cabin   string
+	FromRow int
+	ToRow   int
+}
+
+// Seats expands the layout into the seats of a flight.
+func (l SeatLayout) Seats(flightID int) []Seat {
+	exit := map[int]bool{}
+	for _, row := range l.ExitRows {
+		exit[row] = true
+	}
+	letters := []rune(l.Letters)
+
+	var seats []Seat
+	for row := 1; row <= l.Rows; row++ {
+		for i, letter := range letters {
+			if letter == ' ' {
+				continue
+			}
+			position := SeatMiddle
+			switch {
+			case i == 0 || i == len(letters)-1:
+				position = SeatWindow
+			case letters[i-1] == ' ' || letters[i+1] == ' ':
+				position = SeatAisle
+			}
+			seats = append(seats, Seat{
+				FlightID: flightID,
+				Number:   fmt.Sprintf("%d%c", row, letter),
+				Row:      row,
+				Letter:   string(letter),
+				Cabin:    l.cabinFor(row),
+				ExitRow:  exit[row],
+				Position: position,
+			})
+		}
+	}
+	return seats
+}
+
+func (l SeatLayout) cabinFor(row int) string {
+	for _, cabin := range l.Cabins {
+		if row >= cabin.FromRow && row <= cabin.ToRow {
+			return cabin.Cabin
+		}
+	}
+	return ""
+}
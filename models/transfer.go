@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Transfer records points moved from one client to another. The
+// idempotency key, unique per sender, makes a retried transfer return the
+// original.
+type Transfer struct {
+	ID             uint      `gorm:"primaryKey"`
+	IdempotencyKey string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_transfer_sender_key,priority:2"`
+	Sender         string    `gorm:"type:varchar(255);not null;index:idx_transfer_sender_time;uniqueIndex:idx_transfer_sender_key,priority:1"`
+	Recipient      string    `gorm:"type:varchar(255);not null"`
+	Amount         Money     `gorm:"type:bigint;not null"`
+	CreatedAt      time.Time `gorm:"index:idx_transfer_sender_time"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	WaitlistWaiting   = "waiting"
+	WaitlistOffered   = "offered"
+	WaitlistCancelled = "cancelled"
+)
+
+// WaitlistEntry queues a request for seats on a sold-out flight. When seats
+// are released they are offered to the entry as a SeatHold.
+type WaitlistEntry struct {
+	ID         uint   `gorm:"primaryKey"`
+	FlightID   int    `gorm:"not null;index"`
+	ClientAddr string `gorm:"type:varchar(255);not null"`
+	Seats      int    `gorm:"not null"`
+	FareClass  string `gorm:"size:2"`
+	Priority   int    `gorm:"not null;default:0"` // Loyalty tier rank; higher is served first under the priority policy
+	Status     string `gorm:"size:20;not null;index"`
+	HoldToken  string `gorm:"type:varchar(64)"`
+	CreatedAt  time.Time
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead" // Gave up after the last attempt; can be replayed
+)
+
+// WebhookAllEvents subscribes an endpoint to every event type.
+const WebhookAllEvents = "*"
+
+// WebhookEndpoint is an HTTP endpoint an operator registered for one event
+// type. Deliveries are signed with its secret.
+type WebhookEndpoint struct {
+	ID        uint   `gorm:"primaryKey"`
+	URL       string `gorm:"type:varchar(500);not null"`
+	EventType string `gorm:"size:40;not null;index"`
+	Secret    string `gorm:"type:varchar(128);not null"`
+	Active    bool   `gorm:"not null;default:true"`
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is one event to be sent to one endpoint. Dead deliveries
+// form the dead-letter store.
+type WebhookDelivery struct {
+	ID            uint      `gorm:"primaryKey"`
+	EndpointID    uint      `gorm:"not null;uniqueIndex:idx_webhook_event"`
+	EventKey      string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_webhook_event"`
+	EventType     string    `gorm:"size:40;not null"`
+	Payload       string    `gorm:"type:text;not null"`
+	Status        string    `gorm:"size:12;not null;index:idx_webhook_due"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_due"`
+	ResponseCode  int
+	LastError     string `gorm:"type:varchar(500)"`
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
@@ -0,0 +1,40 @@
+package monitor
+
+import "github.com/Guesstrain/airline/models"
+
+// snapshot captures the part of a flight a condition watches: the whole
+// flight, or one fare class.
+func snapshot(flight models.Flight, fareClass string) models.MonitorSnapshot {
+	if fareClass == "" {
+		return models.MonitorSnapshot{Seats: flight.SeatAvailability, Fare: flight.Airfare}
+	}
+	for _, class := range flight.FareClasses {
+		if class.Code == fareClass {
+			return models.MonitorSnapshot{Seats: class.SeatAvailability, Fare: class.Fare}
+		}
+	}
+	return models.MonitorSnapshot{}
+}
+
+// matches reports whether moving from prev to next should be sent to a
+// monitor with the given condition.
+func matches(condition models.MonitorCondition, prev, next models.MonitorSnapshot) bool {
+	if condition.IsZero() {
+		return true
+	}
+	only := condition
+	only.FareClass = ""
+	if only.IsZero() {
+		return prev != next
+	}
+	if condition.SeatsBelow > 0 && next.Seats < condition.SeatsBelow && next.Seats != prev.Seats {
+		return true
+	}
+	if condition.SeatsAvailable && prev.Seats == 0 && next.Seats > 0 {
+		return true
+	}
+	if condition.FareThreshold > 0 && (prev.Fare < condition.FareThreshold) != (next.Fare < condition.FareThreshold) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+func TestMatches(t *testing.T) {
+	snap := func(seats int, fare models.Money) models.MonitorSnapshot {
+		return models.MonitorSnapshot{Seats: seats, Fare: fare}
+	}
+	tests := []struct {
+		name       string
+		condition  models.MonitorCondition
+		prev, next models.MonitorSnapshot
+		want       bool
+	}{
+		{"no condition sends everything", models.MonitorCondition{}, snap(5, 100), snap(5, 100), true},
+		{"fare class only sends changes", models.MonitorCondition{FareClass: "Y"}, snap(5, 100), snap(4, 100), true},
+		{"fare class only skips no change", models.MonitorCondition{FareClass: "Y"}, snap(5, 100), snap(5, 100), false},
+		{"seats change below threshold", models.MonitorCondition{SeatsBelow: 3}, snap(3, 100), snap(2, 100), true},
+		{"seats change above threshold", models.MonitorCondition{SeatsBelow: 3}, snap(6, 100), snap(5, 100), false},
+		{"fare change below seat threshold", models.MonitorCondition{SeatsBelow: 3}, snap(2, 100), snap(2, 120), false},
+		{"seats become available", models.MonitorCondition{SeatsAvailable: true}, snap(0, 100), snap(2, 100), true},
+		{"seats already available", models.MonitorCondition{SeatsAvailable: true}, snap(1, 100), snap(2, 100), false},
+		{"fare drops below threshold", models.MonitorCondition{FareThreshold: 150}, snap(5, 160), snap(5, 140), true},
+		{"fare rises above threshold", models.MonitorCondition{FareThreshold: 150}, snap(5, 140), snap(5, 160), true},
+		{"fare stays above threshold", models.MonitorCondition{FareThreshold: 150}, snap(5, 170), snap(5, 160), false},
+	}
+	for _, tt := range tests {
+		if got := matches(tt.condition, tt.prev, tt.next); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotReadsFareClass(t *testing.T) {
+	flight := models.Flight{SeatAvailability: 10, Airfare: 200, FareClasses: []models.FareClass{
+		{Code: "J", SeatAvailability: 2, Fare: 900},
+		{Code: "Y", SeatAvailability: 8, Fare: 150},
+	}}
+	if got := snapshot(flight, ""); got != (models.MonitorSnapshot{Seats: 10, Fare: 200}) {
+		t.Errorf("whole flight snapshot = %+v", got)
+	}
+	if got := snapshot(flight, "J"); got != (models.MonitorSnapshot{Seats: 2, Fare: 900}) {
+		t.Errorf("J snapshot = %+v", got)
+	}
+	if got := snapshot(flight, "F"); got != (models.MonitorSnapshot{}) {
+		t.Errorf("unknown class snapshot = %+v, want zero", got)
+	}
+}
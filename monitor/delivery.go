@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryBackoff = 2 * time.Second
+	DefaultMaxAttempts  = 5
+)
+
+// FlightSubject names the callbacks about one flight.
+func FlightSubject(flightID int) string {
+	return "flight:" + strconv.Itoa(flightID)
+}
+
+// RouteSubject names the callbacks about one route.
+func RouteSubject(source, destination string) string {
+	return "route:" + routeKey(source, destination)
+}
+
+func routeKey(source, destination string) string {
+	return strings.ToLower(strings.TrimSpace(source)) + "|" + strings.ToLower(strings.TrimSpace(destination))
+}
+
+// Delivery is a callback waiting for the client to acknowledge it.
+type Delivery struct {
+	ClientAddr  *net.UDPAddr
+	Subject     string
+	Sequence    uint64
+	Payload     []byte
+	Attempts    int
+	NextAttempt time.Time
+}
+
+type deliveryKey struct {
+	clientAddr string
+	subject    string
+}
+
+// Deliveries tracks unacknowledged callbacks. Each carries the full current
+// state, so only the latest callback per client and subject is kept.
+type Deliveries struct {
+	mu          sync.Mutex
+	pending     map[deliveryKey]*Delivery
+	backoff     time.Duration
+	maxAttempts int
+}
+
+// NewDeliveries retries a callback after backoff, doubling the wait each
+// time, and gives up after maxAttempts sends.
+func NewDeliveries(backoff time.Duration, maxAttempts int) *Deliveries {
+	return &Deliveries{
+		pending:     make(map[deliveryKey]*Delivery),
+		backoff:     backoff,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Track records a callback that has just been sent for the first time.
+func (d *Deliveries) Track(clientAddr *net.UDPAddr, subject string, sequence uint64, payload []byte, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending[deliveryKey{clientAddr.String(), subject}] = &Delivery{
+		ClientAddr:  clientAddr,
+		Subject:     subject,
+		Sequence:    sequence,
+		Payload:     payload,
+		Attempts:    1,
+		NextAttempt: now.Add(d.backoff),
+	}
+}
+
+// Ack acknowledges every callback for the subject up to sequence.
+func (d *Deliveries) Ack(clientAddr *net.UDPAddr, subject string, sequence uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := deliveryKey{clientAddr.String(), subject}
+	if delivery, ok := d.pending[key]; ok && delivery.Sequence <= sequence {
+		delete(d.pending, key)
+	}
+}
+
+// Forget drops any pending callback for a client and subject, e.g. once the
+// client stops monitoring it.
+func (d *Deliveries) Forget(clientAddr *net.UDPAddr, subject string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, deliveryKey{clientAddr.String(), subject})
+}
+
+// Due returns the callbacks to send again now and schedules their next
+// attempt. Callbacks that have used up their attempts are dropped.
+func (d *Deliveries) Due(now time.Time) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var due []Delivery
+	for key, delivery := range d.pending {
+		if now.Before(delivery.NextAttempt) {
+			continue
+		}
+		if delivery.Attempts >= d.maxAttempts {
+			delete(d.pending, key)
+			continue
+		}
+		delivery.Attempts++
+		delivery.NextAttempt = now.Add(d.backoff << (delivery.Attempts - 1))
+		due = append(due, *delivery)
+	}
+	return due
+}
@@ -0,0 +1,53 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveriesDueBacksOff(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDeliveries(2*time.Second, 3)
+	client := clientAddr(5000)
+	d.Track(client, FlightSubject(1), 4, []byte("callback"), start)
+
+	if due := d.Due(start.Add(time.Second)); len(due) != 0 {
+		t.Fatalf("due before the backoff: %+v", due)
+	}
+	// Resent after the backoff, then after twice the backoff
+	due := d.Due(start.Add(2 * time.Second))
+	if len(due) != 1 || due[0].Attempts != 2 || !due[0].NextAttempt.Equal(start.Add(6*time.Second)) {
+		t.Fatalf("first retry = %+v, want attempt 2 with the next at +6s", due)
+	}
+	if due := d.Due(start.Add(5 * time.Second)); len(due) != 0 {
+		t.Fatalf("due before the doubled backoff: %+v", due)
+	}
+	due = d.Due(start.Add(6 * time.Second))
+	if len(due) != 1 || due[0].Attempts != 3 || !due[0].NextAttempt.Equal(start.Add(14*time.Second)) {
+		t.Fatalf("second retry = %+v, want attempt 3 with the next at +14s", due)
+	}
+	// Out of attempts: dropped rather than sent again
+	if due := d.Due(start.Add(14 * time.Second)); len(due) != 0 {
+		t.Fatalf("sent past the attempt limit: %+v", due)
+	}
+	if due := d.Due(start.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("dropped delivery came back: %+v", due)
+	}
+}
+
+func TestDeliveriesAck(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDeliveries(time.Second, 5)
+	client := clientAddr(5000)
+	subject := RouteSubject("SIN", "HKG")
+	d.Track(client, subject, 3, nil, start)
+
+	d.Ack(client, subject, 2)
+	if due := d.Due(start.Add(time.Second)); len(due) != 1 {
+		t.Fatalf("an older ack removed the callback: %+v", due)
+	}
+	d.Ack(client, RouteSubject("sin", "hkg"), 3)
+	if due := d.Due(start.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("acknowledged callback still due: %+v", due)
+	}
+}
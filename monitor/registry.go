@@ -0,0 +1,271 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+const (
+	DefaultMaxPerClient = 20
+	DefaultMaxPerFlight = 1000
+)
+
+// Registry holds the clients monitoring each flight. A client has at most
+// one registration per flight, and registrations are bounded per client and
+// per flight. Expired registrations stay until the next Sweep.
+type Registry struct {
+	mu           sync.Mutex
+	flights      map[int][]*models.ClientInfo
+	routes       map[string][]*models.RouteMonitor
+	perClient    map[string]int
+	maxPerClient int
+	maxPerFlight int
+}
+
+// NewRegistry returns an empty registry. A zero bound means unbounded.
+func NewRegistry(maxPerClient, maxPerFlight int) *Registry {
+	return &Registry{
+		flights:      make(map[int][]*models.ClientInfo),
+		routes:       make(map[string][]*models.RouteMonitor),
+		perClient:    make(map[string]int),
+		maxPerClient: maxPerClient,
+		maxPerFlight: maxPerFlight,
+	}
+}
+
+// Expired lists the registrations a sweep removed, so the caller can tell
+// the clients and forget their deliveries.
+type Expired struct {
+	Flights []models.ClientInfo
+	Routes  []models.RouteMonitor
+}
+
+// Register subscribes request.ClientAddr to request.FlightID until
+// request.Expiry, with its NotifyEnd and Condition settings. The flight's
+// current state is the baseline conditions are checked against. If the
+// client is already subscribed the existing registration is updated, keeping
+// the later expiry, and renewed is true. Registrations swept to make room are
+// returned as expired, even when the request still fails.
+func (r *Registry) Register(request models.ClientInfo, flight models.Flight, now time.Time) (info models.ClientInfo, renewed bool, expired Expired, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	flightID, clientAddr := request.FlightID, request.ClientAddr
+	last := snapshot(flight, request.Condition.FareClass)
+	if client := r.find(flightID, clientAddr); client != nil {
+		if request.Expiry.After(client.Expiry) {
+			client.Expiry = request.Expiry
+		}
+		client.NotifyEnd = request.NotifyEnd
+		client.Condition = request.Condition
+		client.Last = last
+		return *client, true, Expired{}, nil
+	}
+	if r.full(flightID, clientAddr) {
+		// Expired registrations may be holding the places
+		expired = r.sweep(now)
+		if r.full(flightID, clientAddr) {
+			return models.ClientInfo{}, false, expired, errors.New("Too many monitors")
+		}
+	}
+	client := &models.ClientInfo{
+		ClientAddr: clientAddr,
+		FlightID:   flightID,
+		Expiry:     request.Expiry,
+		NotifyEnd:  request.NotifyEnd,
+		Condition:  request.Condition,
+		Last:       last,
+	}
+	r.flights[flightID] = append(r.flights[flightID], client)
+	r.perClient[clientAddr.String()]++
+	return *client, false, expired, nil
+}
+
+// Renew moves the expiry of an existing registration to now plus duration.
+func (r *Registry) Renew(flightID int, clientAddr *net.UDPAddr, duration time.Duration, now time.Time) (models.ClientInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := r.find(flightID, clientAddr)
+	if client == nil || !now.Before(client.Expiry) {
+		return models.ClientInfo{}, errors.New("Not monitoring this flight")
+	}
+	client.Expiry = now.Add(duration)
+	return *client, nil
+}
+
+// Cancel removes a client's registration for a flight.
+func (r *Registry) Cancel(flightID int, clientAddr *net.UDPAddr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.flights[flightID]
+	for i, client := range clients {
+		if client.ClientAddr.String() == clientAddr.String() {
+			r.remove(flightID, i)
+			return nil
+		}
+	}
+	return errors.New("Not monitoring this flight")
+}
+
+// Subscribers returns each client with an unexpired flight or route
+// registration, once.
+func (r *Registry) Subscribers(now time.Time) []*net.UDPAddr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[string]bool)
+	var addrs []*net.UDPAddr
+	add := func(addr *net.UDPAddr, expiry time.Time) {
+		if now.Before(expiry) && !seen[addr.String()] {
+			seen[addr.String()] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, clients := range r.flights {
+		for _, client := range clients {
+			add(client.ClientAddr, client.Expiry)
+		}
+	}
+	for _, monitors := range r.routes {
+		for _, monitor := range monitors {
+			add(monitor.ClientAddr, monitor.Expiry)
+		}
+	}
+	return addrs
+}
+
+// List returns a client's unexpired registrations ordered by flight.
+func (r *Registry) List(clientAddr *net.UDPAddr, now time.Time) []models.ClientInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var infos []models.ClientInfo
+	for _, clients := range r.flights {
+		for _, client := range clients {
+			if client.ClientAddr.String() == clientAddr.String() && now.Before(client.Expiry) {
+				infos = append(infos, *client)
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].FlightID < infos[j].FlightID })
+	return infos
+}
+
+// Registration returns a client's unexpired registration for a flight.
+func (r *Registry) Registration(flightID int, clientAddr *net.UDPAddr, now time.Time) (models.ClientInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := r.find(flightID, clientAddr)
+	if client == nil || !now.Before(client.Expiry) {
+		return models.ClientInfo{}, false
+	}
+	return *client, true
+}
+
+// Notify evaluates an update to a flight against every unexpired
+// registration and returns those that should be sent it, each with its next
+// callback sequence number. Every registration's baseline moves to the new
+// state, whether or not it is sent the update.
+func (r *Registry) Notify(flight models.Flight, now time.Time) []models.ClientInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var infos []models.ClientInfo
+	for _, client := range r.flights[flight.ID] {
+		if !now.Before(client.Expiry) {
+			continue
+		}
+		next := snapshot(flight, client.Condition.FareClass)
+		if matches(client.Condition, client.Last, next) {
+			client.Sequence++
+			infos = append(infos, *client)
+		}
+		client.Last = next
+	}
+	return infos
+}
+
+// Sweep removes every flight and route registration that has expired by now
+// and returns them.
+func (r *Registry) Sweep(now time.Time) Expired {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sweep(now)
+}
+
+func (r *Registry) sweep(now time.Time) Expired {
+	var expired Expired
+	for flightID, clients := range r.flights {
+		kept := clients[:0]
+		for _, client := range clients {
+			if now.Before(client.Expiry) {
+				kept = append(kept, client)
+				continue
+			}
+			expired.Flights = append(expired.Flights, *client)
+			r.release(client.ClientAddr.String())
+		}
+		for i := len(kept); i < len(clients); i++ {
+			clients[i] = nil
+		}
+		if len(kept) == 0 {
+			delete(r.flights, flightID)
+		} else {
+			r.flights[flightID] = kept
+		}
+	}
+
+	for key, monitors := range r.routes {
+		kept := monitors[:0]
+		for _, monitor := range monitors {
+			if now.Before(monitor.Expiry) {
+				kept = append(kept, monitor)
+				continue
+			}
+			expired.Routes = append(expired.Routes, *monitor)
+			r.release(monitor.ClientAddr.String())
+		}
+		for i := len(kept); i < len(monitors); i++ {
+			monitors[i] = nil
+		}
+		if len(kept) == 0 {
+			delete(r.routes, key)
+		} else {
+			r.routes[key] = kept
+		}
+	}
+	return expired
+}
+
+func (r *Registry) full(flightID int, clientAddr *net.UDPAddr) bool {
+	if r.maxPerFlight > 0 && len(r.flights[flightID]) >= r.maxPerFlight {
+		return true
+	}
+	return r.maxPerClient > 0 && r.perClient[clientAddr.String()] >= r.maxPerClient
+}
+
+func (r *Registry) remove(flightID, i int) {
+	clients := r.flights[flightID]
+	r.release(clients[i].ClientAddr.String())
+	r.flights[flightID] = append(clients[:i:i], clients[i+1:]...)
+	if len(r.flights[flightID]) == 0 {
+		delete(r.flights, flightID)
+	}
+}
+
+func (r *Registry) release(clientAddr string) {
+	r.perClient[clientAddr]--
+	if r.perClient[clientAddr] <= 0 {
+		delete(r.perClient, clientAddr)
+	}
+}
+
+func (r *Registry) find(flightID int, clientAddr *net.UDPAddr) *models.ClientInfo {
+	for _, client := range r.flights[flightID] {
+		if client.ClientAddr.String() == clientAddr.String() {
+			return client
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+func clientAddr(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}
+}
+
+func register(r *Registry, addr *net.UDPAddr, flightID int, expiry, now time.Time) (models.ClientInfo, bool, Expired, error) {
+	request := models.ClientInfo{ClientAddr: addr, FlightID: flightID, Expiry: expiry}
+	return r.Register(request, models.Flight{ID: flightID}, now)
+}
+
+func TestRegistryLimitsPerClient(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(2, 0)
+	client := clientAddr(5000)
+	for _, flightID := range []int{1, 2} {
+		if _, _, _, err := register(r, client, flightID, now.Add(time.Hour), now); err != nil {
+			t.Fatalf("registering flight %d: %v", flightID, err)
+		}
+	}
+	if _, _, _, err := register(r, client, 3, now.Add(time.Hour), now); err == nil {
+		t.Fatal("third registration accepted past the per-client limit")
+	}
+
+	// Renewing an existing registration does not take another place
+	info, renewed, _, err := register(r, client, 1, now.Add(2*time.Hour), now)
+	if err != nil || !renewed || !info.Expiry.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("renewal = %v, renewed %v, expiry %v", err, renewed, info.Expiry)
+	}
+
+	// Other clients have their own allowance
+	if _, _, _, err := register(r, clientAddr(5001), 3, now.Add(time.Hour), now); err != nil {
+		t.Fatalf("another client: %v", err)
+	}
+
+	if err := r.Cancel(2, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := register(r, client, 3, now.Add(time.Hour), now); err != nil {
+		t.Fatalf("registering after a cancel: %v", err)
+	}
+}
+
+func TestRegistryLimitsPerFlight(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(0, 2)
+	for port := 5000; port < 5002; port++ {
+		if _, _, _, err := register(r, clientAddr(port), 7, now.Add(time.Hour), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, _, err := register(r, clientAddr(5002), 7, now.Add(time.Hour), now); err == nil {
+		t.Fatal("registration accepted past the per-flight limit")
+	}
+	if _, _, _, err := register(r, clientAddr(5002), 8, now.Add(time.Hour), now); err != nil {
+		t.Fatalf("another flight: %v", err)
+	}
+}
+
+func TestRegistryReusesExpiredPlaces(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(1, 0)
+	client := clientAddr(5000)
+	if _, _, _, err := register(r, client, 1, now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+
+	later := now.Add(2 * time.Minute)
+	info, renewed, expired, err := register(r, client, 2, later.Add(time.Hour), later)
+	if err != nil || renewed || info.FlightID != 2 {
+		t.Fatalf("register after expiry = %+v, renewed %v, %v", info, renewed, err)
+	}
+	if len(expired.Flights) != 1 || expired.Flights[0].FlightID != 1 {
+		t.Fatalf("expired = %+v, want the registration for flight 1", expired.Flights)
+	}
+	if infos := r.List(client, later); len(infos) != 1 || infos[0].FlightID != 2 {
+		t.Fatalf("list = %+v, want only flight 2", infos)
+	}
+}
+
+func TestRegistryNotifyAppliesConditions(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(0, 0)
+	flight := models.Flight{ID: 3, SeatAvailability: 0, Airfare: 200}
+	watcher := models.ClientInfo{ClientAddr: clientAddr(5000), FlightID: 3, Expiry: now.Add(time.Hour), Condition: models.MonitorCondition{SeatsAvailable: true}}
+	everything := models.ClientInfo{ClientAddr: clientAddr(5001), FlightID: 3, Expiry: now.Add(time.Hour)}
+	for _, request := range []models.ClientInfo{watcher, everything} {
+		if _, _, _, err := r.Register(request, flight, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flight.Airfare = 180
+	if got := r.Notify(flight, now); len(got) != 1 || got[0].ClientAddr.Port != 5001 || got[0].Sequence != 1 {
+		t.Fatalf("fare change notified %+v, want only the unconditional monitor", got)
+	}
+	flight.SeatAvailability = 4
+	got := r.Notify(flight, now)
+	if len(got) != 2 {
+		t.Fatalf("seats returning notified %d monitors, want 2", len(got))
+	}
+	for _, info := range got {
+		if want := map[int]uint64{5000: 1, 5001: 2}[info.ClientAddr.Port]; info.Sequence != want {
+			t.Errorf("client %d sequence = %d, want %d", info.ClientAddr.Port, info.Sequence, want)
+		}
+	}
+	if got := r.Notify(flight, now.Add(2*time.Hour)); len(got) != 0 {
+		t.Fatalf("expired registrations notified: %+v", got)
+	}
+}
+
+func TestRegistryRouteLimits(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(1, 0)
+	client := clientAddr(5000)
+	route := models.RouteMonitor{ClientAddr: client, Source: "SIN", Destination: "HKG", Expiry: now.Add(time.Hour)}
+	if _, _, _, err := r.RegisterRoute(route, now); err != nil {
+		t.Fatal(err)
+	}
+	// The same route in different case is the same registration
+	route.Source, route.Destination = "sin", "hkg"
+	if _, renewed, _, err := r.RegisterRoute(route, now); err != nil || !renewed {
+		t.Fatalf("re-registering route = renewed %v, %v", renewed, err)
+	}
+	if _, _, _, err := register(r, client, 1, now.Add(time.Hour), now); err == nil {
+		t.Fatal("flight registration accepted past the per-client limit shared with routes")
+	}
+}
@@ -0,0 +1,264 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+// Route is a source and destination pair being watched.
+type Route struct {
+	Source      string
+	Destination string
+}
+
+// RegisterRoute subscribes request.ClientAddr to a route until
+// request.Expiry. A client has one registration per route; registering again
+// updates its window and keeps the later expiry, and renewed is true. Like
+// Register, it returns any registrations swept to make room.
+func (r *Registry) RegisterRoute(request models.RouteMonitor, now time.Time) (info models.RouteMonitor, renewed bool, expired Expired, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := routeKey(request.Source, request.Destination)
+	if monitor := r.findRoute(key, request.ClientAddr); monitor != nil {
+		if request.Expiry.After(monitor.Expiry) {
+			monitor.Expiry = request.Expiry
+		}
+		monitor.DepartAfter, monitor.DepartBefore = request.DepartAfter, request.DepartBefore
+		monitor.NotifyEnd = request.NotifyEnd
+		return *monitor, true, Expired{}, nil
+	}
+	if r.routeFull(key, request.ClientAddr) {
+		expired = r.sweep(now)
+		if r.routeFull(key, request.ClientAddr) {
+			return models.RouteMonitor{}, false, expired, errors.New("Too many monitors")
+		}
+	}
+	monitor := request
+	monitor.Sequence = 0
+	r.routes[key] = append(r.routes[key], &monitor)
+	r.perClient[request.ClientAddr.String()]++
+	return monitor, false, expired, nil
+}
+
+// CancelRoute removes a client's registration for a route.
+func (r *Registry) CancelRoute(source, destination string, clientAddr *net.UDPAddr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := routeKey(source, destination)
+	monitors := r.routes[key]
+	for i, monitor := range monitors {
+		if monitor.ClientAddr.String() == clientAddr.String() {
+			r.release(monitor.ClientAddr.String())
+			r.routes[key] = append(monitors[:i:i], monitors[i+1:]...)
+			if len(r.routes[key]) == 0 {
+				delete(r.routes, key)
+			}
+			return nil
+		}
+	}
+	return errors.New("Not monitoring this route")
+}
+
+// ListRoutes returns a client's unexpired route registrations.
+func (r *Registry) ListRoutes(clientAddr *net.UDPAddr, now time.Time) []models.RouteMonitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var infos []models.RouteMonitor
+	for _, monitors := range r.routes {
+		for _, monitor := range monitors {
+			if monitor.ClientAddr.String() == clientAddr.String() && now.Before(monitor.Expiry) {
+				infos = append(infos, *monitor)
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return routeKey(infos[i].Source, infos[i].Destination) < routeKey(infos[j].Source, infos[j].Destination)
+	})
+	return infos
+}
+
+// Routes returns every route with an unexpired registration.
+func (r *Registry) Routes(now time.Time) []Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var routes []Route
+	for _, monitors := range r.routes {
+		for _, monitor := range monitors {
+			if now.Before(monitor.Expiry) {
+				routes = append(routes, Route{Source: monitor.Source, Destination: monitor.Destination})
+				break
+			}
+		}
+	}
+	return routes
+}
+
+// NotifyRoute returns the registrations on a route whose window covers the
+// changed flight, each with its next callback sequence number. loc is the
+// zone of the flight's source airport.
+func (r *Registry) NotifyRoute(route Route, flight models.Flight, loc *time.Location, now time.Time) []models.RouteMonitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var infos []models.RouteMonitor
+	for _, monitor := range r.routes[routeKey(route.Source, route.Destination)] {
+		if now.Before(monitor.Expiry) && monitor.Covers(flight, loc) {
+			monitor.Sequence++
+			infos = append(infos, *monitor)
+		}
+	}
+	return infos
+}
+
+func (r *Registry) routeFull(key string, clientAddr *net.UDPAddr) bool {
+	if r.maxPerFlight > 0 && len(r.routes[key]) >= r.maxPerFlight {
+		return true
+	}
+	return r.maxPerClient > 0 && r.perClient[clientAddr.String()] >= r.maxPerClient
+}
+
+func (r *Registry) findRoute(key string, clientAddr *net.UDPAddr) *models.RouteMonitor {
+	for _, monitor := range r.routes[key] {
+		if monitor.ClientAddr.String() == clientAddr.String() {
+			return monitor
+		}
+	}
+	return nil
+}
+
+// FlightChange is a difference found on a watched route.
+type FlightChange struct {
+	Flight models.Flight
+	Change string
+}
+
+// RouteWatcher remembers the flights last seen on each route so polls can
+// be turned into changes.
+type RouteWatcher struct {
+	mu      sync.Mutex
+	flights map[string]map[int]models.Flight
+}
+
+func NewRouteWatcher() *RouteWatcher {
+	return &RouteWatcher{flights: make(map[string]map[int]models.Flight)}
+}
+
+// Seed records a route's flights as the baseline, unless it is already watched.
+func (w *RouteWatcher) Seed(route Route, flights []models.Flight) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := routeKey(route.Source, route.Destination)
+	if _, ok := w.flights[key]; !ok {
+		w.flights[key] = byID(flights)
+	}
+}
+
+// Diff compares a route's current flights with the last poll and records
+// them as the new baseline. A route seen for the first time has no changes.
+func (w *RouteWatcher) Diff(route Route, flights []models.Flight) []FlightChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := routeKey(route.Source, route.Destination)
+	current := byID(flights)
+	previous, ok := w.flights[key]
+	w.flights[key] = current
+	if !ok {
+		return nil
+	}
+
+	var changes []FlightChange
+	for _, flight := range flights {
+		before, existed := previous[flight.ID]
+		if !existed {
+			changes = append(changes, FlightChange{flight, models.ChangeFlightAdded})
+			continue
+		}
+		changes = append(changes, compareFlight(before, flight)...)
+	}
+	for id, flight := range previous {
+		if _, ok := current[id]; !ok {
+			changes = append(changes, FlightChange{flight, models.ChangeFlightCancelled})
+		}
+	}
+	return changes
+}
+
+// Observe compares one flight on a watched route with its baseline and
+// records it as the new baseline, so every change made through the server
+// is seen, not just what differs by the next poll. Unwatched routes have no
+// baseline and no changes.
+func (w *RouteWatcher) Observe(route Route, flight models.Flight) []FlightChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	previous, ok := w.flights[routeKey(route.Source, route.Destination)]
+	if !ok {
+		return nil
+	}
+	before, existed := previous[flight.ID]
+	previous[flight.ID] = flight
+	if !existed {
+		return []FlightChange{{flight, models.ChangeFlightAdded}}
+	}
+	return compareFlight(before, flight)
+}
+
+// compareFlight lists the changes a route monitor hears about between two
+// states of a flight, looking at every fare class as well as the flight.
+func compareFlight(before, flight models.Flight) []FlightChange {
+	var changes []FlightChange
+	if before.DepartureTime != flight.DepartureTime || before.ArrivalTime != flight.ArrivalTime {
+		changes = append(changes, FlightChange{flight, models.ChangeSchedule})
+	}
+	repriced := before.Airfare != flight.Airfare
+	returned := flight.SeatAvailability > before.SeatAvailability
+	classes := make(map[string]models.FareClass, len(before.FareClasses))
+	for _, class := range before.FareClasses {
+		classes[class.Code] = class
+	}
+	for _, class := range flight.FareClasses {
+		old, ok := classes[class.Code]
+		if !ok {
+			repriced = true
+			continue
+		}
+		repriced = repriced || old.Fare != class.Fare
+		returned = returned || class.SeatAvailability > old.SeatAvailability
+	}
+	if len(classes) != len(flight.FareClasses) {
+		repriced = true
+	}
+	if repriced {
+		changes = append(changes, FlightChange{flight, models.ChangePrice})
+	}
+	if returned {
+		changes = append(changes, FlightChange{flight, models.ChangeSeatsReturned})
+	}
+	return changes
+}
+
+// Retain forgets the baselines of routes no longer watched.
+func (w *RouteWatcher) Retain(routes []Route) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	keep := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		keep[routeKey(route.Source, route.Destination)] = true
+	}
+	for key := range w.flights {
+		if !keep[key] {
+			delete(w.flights, key)
+		}
+	}
+}
+
+func byID(flights []models.Flight) map[int]models.Flight {
+	indexed := make(map[int]models.Flight, len(flights))
+	for _, flight := range flights {
+		indexed[flight.ID] = flight
+	}
+	return indexed
+}
@@ -0,0 +1,142 @@
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+// Input is what an Engine knows about the seats being priced.
+type Input struct {
+	BaseFare  models.Money
+	Capacity  int // Zero when the capacity is unknown
+	Available int
+	Departure time.Time // Zero when the schedule is unknown
+	Now       time.Time
+}
+
+// LoadFactor returns the share of capacity already sold, between 0 and 1.
+func (in Input) LoadFactor() float64 {
+	if in.Capacity <= 0 {
+		return 0
+	}
+	sold := in.Capacity - in.Available
+	if sold <= 0 {
+		return 0
+	}
+	return math.Min(float64(sold)/float64(in.Capacity), 1)
+}
+
+// DaysToDeparture returns the whole days left before departure, and false
+// when the departure time is unknown.
+func (in Input) DaysToDeparture() (int, bool) {
+	if in.Departure.IsZero() {
+		return 0, false
+	}
+	days := int(in.Departure.Sub(in.Now).Hours() / 24)
+	if days < 0 {
+		days = 0
+	}
+	return days, true
+}
+
+// Engine computes the fare charged for one seat.
+type Engine interface {
+	Price(in Input) models.Money
+}
+
+// Static charges the base fare.
+type Static struct{}
+
+func (Static) Price(in Input) models.Money {
+	return in.BaseFare
+}
+
+// Rule returns a multiplier applied to the base fare.
+type Rule interface {
+	Multiplier(in Input) float64
+}
+
+// Step applies Multiplier once a rule's measure reaches Threshold.
+type Step struct {
+	Threshold  float64
+	Multiplier float64
+}
+
+// RuleEngine multiplies the base fare by every rule's multiplier and keeps
+// the result between MinMultiplier and MaxMultiplier times the base fare.
+type RuleEngine struct {
+	Rules         []Rule
+	MinMultiplier float64
+	MaxMultiplier float64
+}
+
+func (e *RuleEngine) Price(in Input) models.Money {
+	multiplier := 1.0
+	for _, rule := range e.Rules {
+		multiplier *= rule.Multiplier(in)
+	}
+	if e.MinMultiplier > 0 && multiplier < e.MinMultiplier {
+		multiplier = e.MinMultiplier
+	}
+	if e.MaxMultiplier > 0 && multiplier > e.MaxMultiplier {
+		multiplier = e.MaxMultiplier
+	}
+	return in.BaseFare.Scale(multiplier)
+}
+
+// LoadFactorRule raises the fare as the flight fills. Steps are checked from
+// the first; the first step whose Threshold the load factor reaches applies.
+type LoadFactorRule struct {
+	Steps []Step
+}
+
+func (r LoadFactorRule) Multiplier(in Input) float64 {
+	loadFactor := in.LoadFactor()
+	for _, step := range r.Steps {
+		if loadFactor >= step.Threshold {
+			return step.Multiplier
+		}
+	}
+	return 1
+}
+
+// DaysToDepartureRule raises the fare close to departure. The first step
+// whose Threshold, in days, is not exceeded applies.
+type DaysToDepartureRule struct {
+	Steps []Step
+}
+
+func (r DaysToDepartureRule) Multiplier(in Input) float64 {
+	days, ok := in.DaysToDeparture()
+	if !ok {
+		return 1
+	}
+	for _, step := range r.Steps {
+		if float64(days) <= step.Threshold {
+			return step.Multiplier
+		}
+	}
+	return 1
+}
+
+// DefaultEngine returns the standard load factor and advance purchase rules.
+func DefaultEngine() *RuleEngine {
+	return &RuleEngine{
+		Rules: []Rule{
+			LoadFactorRule{Steps: []Step{
+				{Threshold: 0.9, Multiplier: 1.5},
+				{Threshold: 0.75, Multiplier: 1.25},
+				{Threshold: 0.5, Multiplier: 1.1},
+			}},
+			DaysToDepartureRule{Steps: []Step{
+				{Threshold: 3, Multiplier: 1.3},
+				{Threshold: 7, Multiplier: 1.15},
+				{Threshold: 21, Multiplier: 1.05},
+			}},
+		},
+		MinMultiplier: 0.5,
+		MaxMultiplier: 3,
+	}
+}
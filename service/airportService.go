@@ -0,0 +1,289 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultAutocompleteLimit = 8
+
+type AirportService interface {
+	Resolve(input string) ([]models.Airport, error)
+	Known(place string) (bool, error)
+	Autocomplete(prefix string, limit int) ([]models.Airport, error)
+	ValidateFlight(flight models.Flight) error
+	AuditFlights() (map[int]error, error)
+}
+
+type AirportServiceImpl struct {
+	DB *gorm.DB
+}
+
+// Resolve returns the airports matching a code, name, city or alias,
+// ignoring case. A city can resolve to several airports.
+func (a *AirportServiceImpl) Resolve(input string) ([]models.Airport, error) {
+	airports, err := loadAirports(a.DB)
+	if err != nil {
+		return nil, err
+	}
+	return matchAirports(airports, input), nil
+}
+
+// Known reports whether a place names an airport or, like searches do for
+// places missing from the airports table, appears as typed on a stored flight.
+func (a *AirportServiceImpl) Known(place string) (bool, error) {
+	matches, err := a.Resolve(place)
+	if err != nil || len(matches) > 0 {
+		return len(matches) > 0, err
+	}
+	name := strings.ToLower(strings.TrimSpace(place))
+	var count int64
+	err = a.DB.Model(&models.Flight{}).Where("LOWER(source) = ? OR LOWER(destination) = ?", name, name).Count(&count).Error
+	return count > 0, err
+}
+
+// Autocomplete returns airports whose code, name, city or alias starts with
+// prefix. Exact code matches rank first, then city, name and alias matches.
+// At most MaxPageSize airports are returned.
+func (a *AirportServiceImpl) Autocomplete(prefix string, limit int) ([]models.Airport, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix == "" {
+		return nil, errors.New("Empty search text")
+	}
+	limit = clampPageSize(limit, DefaultAutocompleteLimit, MaxPageSize)
+	airports, err := loadAirports(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	rank := func(airport models.Airport) int {
+		names := airport.Names()
+		if names[0] == prefix {
+			return 0
+		}
+		for i, name := range names[1:] {
+			if strings.HasPrefix(name, prefix) {
+				return i + 1
+			}
+		}
+		if strings.HasPrefix(names[0], prefix) {
+			return len(names)
+		}
+		return -1
+	}
+
+	type candidate struct {
+		airport models.Airport
+		rank    int
+	}
+	var candidates []candidate
+	for _, airport := range airports {
+		if r := rank(airport); r >= 0 {
+			candidates = append(candidates, candidate{airport, r})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+		return candidates[i].airport.Code < candidates[j].airport.Code
+	})
+
+	var matches []models.Airport
+	for _, c := range candidates {
+		if len(matches) == limit {
+			break
+		}
+		matches = append(matches, c.airport)
+	}
+	return matches, nil
+}
+
+// ValidateFlight checks that the source and destination of a flight are known airports.
+func (a *AirportServiceImpl) ValidateFlight(flight models.Flight) error {
+	airports, err := loadAirports(a.DB)
+	if err != nil {
+		return err
+	}
+	return validateFlight(airports, flight)
+}
+
+func validateFlight(airports []models.Airport, flight models.Flight) error {
+	if len(matchAirports(airports, flight.Source)) == 0 {
+		return errors.New("Unknown source airport: " + flight.Source)
+	}
+	if len(matchAirports(airports, flight.Destination)) == 0 {
+		return errors.New("Unknown destination airport: " + flight.Destination)
+	}
+	if index := indexAirports(airports); index.key(flight.Source) == index.key(flight.Destination) {
+		return errors.New("Source and destination are the same airport")
+	}
+	return nil
+}
+
+// AuditFlights validates every stored flight and returns the problem found
+// with each invalid one, keyed by flight ID.
+func (a *AirportServiceImpl) AuditFlights() (map[int]error, error) {
+	airports, err := loadAirports(a.DB)
+	if err != nil {
+		return nil, err
+	}
+	var flights []models.Flight
+	if err := a.DB.Order("id").Find(&flights).Error; err != nil {
+		return nil, err
+	}
+	problems := make(map[int]error)
+	for _, flight := range flights {
+		if err := validateFlight(airports, flight); err != nil {
+			problems[flight.ID] = err
+		}
+	}
+	return problems, nil
+}
+
+// SeedAirports adds the airports whose codes are not stored yet, leaving
+// existing rows as the operator edited them, and returns how many were added.
+func SeedAirports(db *gorm.DB, airports []models.Airport) (int64, error) {
+	if len(airports) == 0 {
+		return 0, nil
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&airports)
+	return result.RowsAffected, result.Error
+}
+
+// ReadAirports parses airports from CSV with the columns code, name, city,
+// country, aliases and time zone. Aliases are comma-separated inside one
+// quoted field; a first row starting with "code" is taken as a header.
+func ReadAirports(r io.Reader) ([]models.Airport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][0], "code") {
+		records = records[1:]
+	}
+	airports := make([]models.Airport, 0, len(records))
+	for i, record := range records {
+		airport := models.Airport{
+			Code:     strings.ToUpper(strings.TrimSpace(record[0])),
+			Name:     strings.TrimSpace(record[1]),
+			City:     strings.TrimSpace(record[2]),
+			Country:  strings.TrimSpace(record[3]),
+			Aliases:  strings.TrimSpace(record[4]),
+			TimeZone: strings.TrimSpace(record[5]),
+		}
+		if len(airport.Code) != 3 || airport.Name == "" || airport.City == "" {
+			return nil, fmt.Errorf("Invalid airport on row %d", i+1)
+		}
+		if airport.TimeZone != "" {
+			if _, err := time.LoadLocation(airport.TimeZone); err != nil {
+				return nil, fmt.Errorf("Unknown time zone %q for %s", airport.TimeZone, airport.Code)
+			}
+		}
+		airports = append(airports, airport)
+	}
+	return airports, nil
+}
+
+func loadAirports(db *gorm.DB) ([]models.Airport, error) {
+	var airports []models.Airport
+	if err := db.Order("code").Find(&airports).Error; err != nil {
+		return nil, err
+	}
+	return airports, nil
+}
+
+func matchAirports(airports []models.Airport, input string) []models.Airport {
+	input = strings.ToLower(strings.TrimSpace(input))
+	var matches []models.Airport
+	for _, airport := range airports {
+		for _, name := range airport.Names() {
+			if name == input {
+				matches = append(matches, airport)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// locationNames normalises user input to every lowercase name stored flights
+// may use for the same place, so "singapore", "SIN" and "Changi" all match.
+// Unknown input is matched as typed, ignoring case.
+func locationNames(db *gorm.DB, input string) ([]string, error) {
+	airports, err := loadAirports(db)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{strings.ToLower(strings.TrimSpace(input))}
+	for _, airport := range matchAirports(airports, input) {
+		names = append(names, airport.Names()...)
+	}
+	return names, nil
+}
+
+// airportIndex maps every lowercase airport name to its IATA code, and every
+// code to the airport's time zone.
+type airportIndex struct {
+	codes map[string]string
+	zones map[string]*time.Location
+}
+
+func newAirportIndex(db *gorm.DB) (airportIndex, error) {
+	airports, err := loadAirports(db)
+	if err != nil {
+		return airportIndex{}, err
+	}
+	return indexAirports(airports), nil
+}
+
+func indexAirports(airports []models.Airport) airportIndex {
+	index := airportIndex{codes: map[string]string{}, zones: map[string]*time.Location{}}
+	for _, airport := range airports {
+		for _, name := range airport.Names() {
+			index.codes[name] = airport.Code
+		}
+		index.zones[airport.Code] = airport.Location()
+	}
+	return index
+}
+
+// key returns the canonical identifier for a stored or typed location.
+func (index airportIndex) key(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if code, ok := index.codes[name]; ok {
+		return code
+	}
+	return name
+}
+
+// location returns the time zone of a stored location, falling back to the
+// server's zone for places missing from the airports table.
+func (index airportIndex) location(name string) *time.Location {
+	if loc, ok := index.zones[index.key(name)]; ok {
+		return loc
+	}
+	return time.Local
+}
+
+// departure parses a flight's departure time in the zone of its source.
+func (index airportIndex) departure(flight models.Flight) (time.Time, error) {
+	return models.ParseFlightTimeIn(flight.DepartureTime, index.location(flight.Source))
+}
+
+// arrival parses a flight's arrival time in the zone of its destination.
+func (index airportIndex) arrival(flight models.Flight) (time.Time, error) {
+	return models.ParseFlightTimeIn(flight.ArrivalTime, index.location(flight.Destination))
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExpirePoints expires every lot past its expiry date, recording an expiry
+// ledger entry per lot, and returns the entries written.
+func (p *PointsServiceImpl) ExpirePoints() ([]models.PointsEntry, error) {
+	now := p.now()
+	var clients []string
+	err := p.DB.Model(&models.PointsLot{}).
+		Where("remaining > 0 AND expires_at <= ?", now).
+		Distinct().Pluck("client_addr", &clients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []models.PointsEntry
+	for _, clientAddr := range clients {
+		var entries []models.PointsEntry
+		err := p.DB.Transaction(func(tx *gorm.DB) error {
+			clientPoints, err := p.lockPoints(tx, clientAddr)
+			if err != nil {
+				return err
+			}
+			var lots []models.PointsLot
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("client_addr = ? AND remaining > 0 AND expires_at <= ?", clientAddr, now).
+				Order("expires_at, id").Find(&lots).Error
+			if err != nil {
+				return err
+			}
+			for _, lot := range lots {
+				if err := tx.Model(&lot).UpdateColumn("remaining", 0).Error; err != nil {
+					return err
+				}
+				amount := lot.Remaining
+				if amount > clientPoints.Points {
+					amount = clientPoints.Points
+				}
+				if amount <= 0 {
+					continue
+				}
+				description := "Points earned " + lot.EarnedAt.Format("2006-01-02") + " expired"
+				clientPoints, err = p.postPoints(tx, clientPoints, models.PointsExpiry, -amount, nil, description)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, models.PointsEntry{
+					ClientAddr:  clientAddr,
+					Type:        models.PointsExpiry,
+					Amount:      -amount,
+					Balance:     clientPoints.Points,
+					Description: description,
+					CreatedAt:   now,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, entries...)
+	}
+	return expired, nil
+}
+
+// ExpiringPoints returns a client's lots that expire within the given window,
+// soonest first, and their total.
+func (p *PointsServiceImpl) ExpiringPoints(clientAddr string, within time.Duration) ([]models.PointsLot, models.Money, error) {
+	if within <= 0 {
+		within = DefaultExpiryWindow
+	}
+	now := p.now()
+	var lots []models.PointsLot
+	err := p.DB.Where("client_addr = ? AND remaining > 0 AND expires_at > ? AND expires_at <= ?", clientAddr, now, now.Add(within)).
+		Order("expires_at, id").Limit(MaxExpiringLots).Find(&lots).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var total models.Money
+	for _, lot := range lots {
+		total += lot.Remaining
+	}
+	return lots, total, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Guesstrain/airline/clock"
+	"github.com/Guesstrain/airline/models"
+)
+
+func TestExpirePointsPastLifetime(t *testing.T) {
+	db := openTestDB(t)
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.Local)
+	fixed := clock.NewFixed(start)
+	points := &PointsServiceImpl{DB: db, Lifetime: 30 * 24 * time.Hour, Clock: fixed}
+	const client = "10.0.0.1:5000"
+
+	if _, err := points.Earn(client, 100*models.MoneyScale, 0, "First flight"); err != nil {
+		t.Fatal(err)
+	}
+	fixed.Advance(10 * 24 * time.Hour)
+	if _, err := points.Earn(client, 50*models.MoneyScale, 0, "Second flight"); err != nil {
+		t.Fatal(err)
+	}
+	// Spending draws on the lot that expires first
+	if _, err := points.Redeem(client, 30*models.MoneyScale, 0, "Upgrade"); err != nil {
+		t.Fatal(err)
+	}
+
+	lots, total, err := points.ExpiringPoints(client, 25*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || total != 70*models.MoneyScale {
+		t.Fatalf("expiring within 25 days = %d lot(s) totalling %v, want 1 totalling 70", len(lots), total)
+	}
+
+	expired, err := points.ExpirePoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expired %d lot(s) before the lifetime passed", len(expired))
+	}
+
+	fixed.Set(start.Add(31 * 24 * time.Hour))
+	expired, err = points.ExpirePoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Type != models.PointsExpiry || expired[0].Amount != -70*models.MoneyScale || expired[0].Balance != 50*models.MoneyScale {
+		t.Fatalf("expired = %+v, want one expiry of -70 leaving 50", expired)
+	}
+
+	var entries []models.PointsEntry
+	if err := db.Where("client_addr = ? AND type = ?", client, models.PointsExpiry).Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Amount != -70*models.MoneyScale {
+		t.Fatalf("ledger expiry entries = %+v, want one of -70", entries)
+	}
+
+	var stored []models.PointsLot
+	if err := db.Where("client_addr = ?", client).Order("id").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].Remaining != 0 || stored[1].Remaining != 50*models.MoneyScale {
+		t.Fatalf("lots = %+v, want remaining 0 and 50", stored)
+	}
+
+	lots, total, err = points.ExpiringPoints(client, 10*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].ID != stored[1].ID || total != 50*models.MoneyScale {
+		t.Fatalf("expiring within 10 days = %+v totalling %v, want the second lot totalling 50", lots, total)
+	}
+
+	// Running again at the same time expires nothing more
+	expired, err = points.ExpirePoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("second run expired %+v", expired)
+	}
+}
@@ -2,51 +2,284 @@ package service
 
 import (
 	"errors"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Guesstrain/airline/events"
 	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
 	"gorm.io/gorm"
 )
 
+// ErrFlightNotFound is returned when a request names a flight that does not exist.
+var ErrFlightNotFound = errors.New("Flight not found")
+
 type FlightService interface {
 	QueryFlights(source, destination string) ([]models.Flight, error)
 	GetFlightDetails(flightID int) (*models.Flight, error)
-	ReserveSeats(flightID, seats int) (models.Flight, error)
+	ReserveSeats(clientAddr string, flightID, seats int, fareClass, quoteID string) (models.Booking, models.Flight, error)
+	ReserveSeatsWithPoints(clientAddr string, flightID, seats int, fareClass, quoteID string, points models.Money) (models.Booking, models.Flight, error)
+	SearchFlights(search models.FlightSearch) (models.FlightPage, error)
+	OnRoute(flight models.Flight, source, destination string) (bool, error)
+	DepartureZone(flight models.Flight) (*time.Location, error)
 }
 
 type FlightServiceImpl struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Pricing pricing.Engine
+	Quotes  *QuoteServiceImpl
+	Points  *PointsServiceImpl
 }
 
-// QueryFlights returns flights based on source and destination.
+// QueryFlights returns flights based on source and destination, at current fares.
 func (f *FlightServiceImpl) QueryFlights(source, destination string) ([]models.Flight, error) {
+	route, err := routeQuery(f.DB, source, destination)
+	if err != nil {
+		return nil, err
+	}
 	var flights []models.Flight
-	if err := f.DB.Where("source = ? AND destination = ?", source, destination).Find(&flights).Error; err != nil {
+	if err := route.Preload("FareClasses").Order("id").Find(&flights).Error; err != nil {
+		return nil, err
+	}
+	index, err := newAirportIndex(f.DB)
+	if err != nil {
 		return nil, err
 	}
+	priceFlights(f.Pricing, index, flights, time.Now())
 	return flights, nil
 }
 
-// GetFlightDetails returns flight details by flight ID.
+// GetFlightDetails returns flight details by flight ID, at current fares.
 func (f *FlightServiceImpl) GetFlightDetails(flightID int) (*models.Flight, error) {
 	var flight models.Flight
-	if err := f.DB.First(&flight, flightID).Error; err != nil {
+	if err := f.DB.Preload("FareClasses").First(&flight, flightID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("Flight not found")
+			return nil, ErrFlightNotFound
 		}
 		return nil, err
 	}
+	index, err := newAirportIndex(f.DB)
+	if err != nil {
+		return nil, err
+	}
+	flight = priceFlight(f.Pricing, index, flight, time.Now())
 	return &flight, nil
 }
 
-// ReserveSeats reserves a specified number of seats for a flight.
-func (f *FlightServiceImpl) ReserveSeats(flightID, seats int) (models.Flight, error) {
+// ReserveSeats reserves a specified number of seats for a flight, from a
+// fare class when one is given, and records the booking at the quoted price
+// when a quote is given or the current fare otherwise.
+func (f *FlightServiceImpl) ReserveSeats(clientAddr string, flightID, seats int, fareClass, quoteID string) (models.Booking, models.Flight, error) {
+	var booking models.Booking
+	var flight models.Flight
+	err := f.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		booking, flight, err = f.reserve(tx, clientAddr, flightID, seats, fareClass, quoteID)
+		return err
+	})
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	return booking, flight, nil
+}
+
+// ReserveSeatsWithPoints reserves seats like ReserveSeats and pays up to
+// points of the price with points, or the whole price when points is zero.
+// Both happen in one transaction, so a failed debit leaves the seats unsold.
+func (f *FlightServiceImpl) ReserveSeatsWithPoints(clientAddr string, flightID, seats int, fareClass, quoteID string, points models.Money) (models.Booking, models.Flight, error) {
+	if points < 0 {
+		return models.Booking{}, models.Flight{}, errors.New("Invalid number of points")
+	}
+	var booking models.Booking
 	var flight models.Flight
-	if err := f.DB.First(&flight, flightID).Error; err != nil {
-		return models.Flight{}, errors.New("Flight not found")
+	err := f.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		booking, flight, err = f.reserve(tx, clientAddr, flightID, seats, fareClass, quoteID)
+		if err != nil {
+			return err
+		}
+		covered := booking.TotalPrice
+		if points > 0 && points < covered {
+			covered = points
+		}
+		booking, err = f.Points.payBooking(tx, clientAddr, booking, covered)
+		return err
+	})
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	return booking, flight, nil
+}
+
+func (f *FlightServiceImpl) reserve(tx *gorm.DB, clientAddr string, flightID, seats int, fareClass, quoteID string) (models.Booking, models.Flight, error) {
+	locked, err := lockFlight(tx, flightID)
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	index, err := newAirportIndex(tx)
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	flight := priceFlight(f.Pricing, index, locked, time.Now())
+	unitPrice, err := f.Quotes.unitPrice(tx, quoteID, clientAddr, flight, seats, fareClass)
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	if err := sellSeats(tx, &flight, clientAddr, fareClass, seats); err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	booking, err := recordBooking(tx, clientAddr, flightID, fareClass, seats, unitPrice)
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	err = record(tx, events.FlightChange(flightID, models.ChangeBooking), events.BookingCreation(booking))
+	if err != nil {
+		return models.Booking{}, models.Flight{}, err
+	}
+	return booking, flight, nil
+}
+
+// SearchFlights returns one page of direct flights matching the search
+// filters. Fares are dynamic and departures are compared in each airport's
+// zone, so the price and departure filters and the ordering are applied
+// after loading rather than in SQL.
+func (f *FlightServiceImpl) SearchFlights(search models.FlightSearch) (models.FlightPage, error) {
+	offset, err := DecodeCursor(search.Cursor)
+	if err != nil {
+		return models.FlightPage{}, err
+	}
+	pageSize := clampPageSize(search.PageSize, DefaultPageSize, MaxPageSize)
+
+	route, err := routeQuery(f.DB, search.Source, search.Destination)
+	if err != nil {
+		return models.FlightPage{}, err
+	}
+	var flights []models.Flight
+	if err := applySearchFilters(route, search.SearchFilters).Preload("FareClasses").Order("id").Find(&flights).Error; err != nil {
+		return models.FlightPage{}, err
+	}
+	index, err := newAirportIndex(f.DB)
+	if err != nil {
+		return models.FlightPage{}, err
+	}
+	priceFlights(f.Pricing, index, flights, time.Now())
+
+	matching := flights[:0]
+	for _, flight := range flights {
+		if matchesPrice(flight.Airfare, search.SearchFilters) && departsWithin(index, flight, search.SearchFilters) {
+			matching = append(matching, flight)
+		}
+	}
+	sortFlights(index, matching, search.SortBy)
+	page, next := paginate(matching, offset, pageSize)
+	return models.FlightPage{Flights: page, NextCursor: next, Offset: offset}, nil
+}
+
+// OnRoute reports whether a flight flies between two locations, matching
+// them against the airports table the way QueryFlights does.
+func (f *FlightServiceImpl) OnRoute(flight models.Flight, source, destination string) (bool, error) {
+	sources, err := locationNames(f.DB, source)
+	if err != nil {
+		return false, err
+	}
+	destinations, err := locationNames(f.DB, destination)
+	if err != nil {
+		return false, err
+	}
+	return containsName(sources, flight.Source) && containsName(destinations, flight.Destination), nil
+}
+
+// DepartureZone returns the time zone of a flight's source airport, in which
+// its departure time and route monitor windows are read.
+func (f *FlightServiceImpl) DepartureZone(flight models.Flight) (*time.Location, error) {
+	index, err := newAirportIndex(f.DB)
+	if err != nil {
+		return nil, err
+	}
+	return index.location(flight.Source), nil
+}
+
+func containsName(names []string, name string) bool {
+	return contains(names, strings.ToLower(name))
+}
+
+// routeQuery matches flights between two locations after normalising them
+// against the airports table.
+func routeQuery(db *gorm.DB, source, destination string) (*gorm.DB, error) {
+	sources, err := locationNames(db, source)
+	if err != nil {
+		return nil, err
+	}
+	destinations, err := locationNames(db, destination)
+	if err != nil {
+		return nil, err
+	}
+	return db.Where("LOWER(source) IN ? AND LOWER(destination) IN ?", sources, destinations), nil
+}
+
+// applySearchFilters adds the SQL conditions for the non-zero seat and
+// carrier filters.
+func applySearchFilters(query *gorm.DB, filters models.SearchFilters) *gorm.DB {
+	if filters.MinSeats > 0 {
+		query = query.Where("seat_availability >= ?", filters.MinSeats)
+	}
+	if filters.Carrier != "" {
+		query = query.Where("carrier = ?", filters.Carrier)
+	}
+	return query
+}
+
+// departsWithin reports whether a flight departs inside the filters'
+// departure window, read in the zone of its source airport.
+func departsWithin(index airportIndex, flight models.Flight, filters models.SearchFilters) bool {
+	return models.DepartsWithin(flight.DepartureTime, filters.DepartAfter, filters.DepartBefore, index.location(flight.Source))
+}
+
+func matchesPrice(price models.Money, filters models.SearchFilters) bool {
+	if filters.MinPrice > 0 && price < filters.MinPrice {
+		return false
+	}
+	return filters.MaxPrice <= 0 || price <= filters.MaxPrice
+}
+
+// sortFlights orders flights by price (the default), departure or block
+// time, with each end of a flight read in its airport's zone. Flights without
+// a parseable schedule go last when sorting by departure or duration.
+func sortFlights(index airportIndex, flights []models.Flight, sortBy string) {
+	departure := func(flight models.Flight) (int64, bool) {
+		t, err := index.departure(flight)
+		return t.UnixNano(), err == nil
 	}
-	if flight.SeatAvailability < seats {
-		return models.Flight{}, errors.New("Insufficient seats available")
+	duration := func(flight models.Flight) (int64, bool) {
+		departure, err := index.departure(flight)
+		if err != nil {
+			return 0, false
+		}
+		arrival, err := index.arrival(flight)
+		if err != nil {
+			return 0, false
+		}
+		return int64(arrival.Sub(departure)), true
 	}
-	flight.SeatAvailability -= seats
-	return flight, f.DB.Save(&flight).Error
+	sort.SliceStable(flights, func(i, j int) bool {
+		a, b := flights[i], flights[j]
+		switch sortBy {
+		case "duration":
+			da, okA := duration(a)
+			db, okB := duration(b)
+			if okA != okB {
+				return okA
+			}
+			return da < db
+		case "departure":
+			da, okA := departure(a)
+			db, okB := departure(b)
+			if okA != okB {
+				return okA
+			}
+			return da < db
+		}
+		return a.Airfare < b.Airfare
+	})
 }
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultHoldDuration = 5 * time.Minute
+	MaxHoldDuration     = 30 * time.Minute
+)
+
+type HoldService interface {
+	HoldSeats(clientAddr string, flightID, seats int, fareClass, quoteID string, duration time.Duration) (models.SeatHold, models.Flight, error)
+	ConfirmHold(token, clientAddr string) (models.SeatHold, models.Booking, models.Flight, error)
+	ReleaseHold(token, clientAddr string) (models.SeatHold, error)
+	ReleaseExpired(now time.Time) ([]models.SeatHold, error)
+}
+
+type HoldServiceImpl struct {
+	DB      *gorm.DB
+	Pricing pricing.Engine
+	Quotes  *QuoteServiceImpl
+}
+
+// HoldSeats takes seats out of a flight's availability for a limited time
+// and locks the quoted price, or the current fare, for them.
+func (h *HoldServiceImpl) HoldSeats(clientAddr string, flightID, seats int, fareClass, quoteID string, duration time.Duration) (models.SeatHold, models.Flight, error) {
+	var hold models.SeatHold
+	var flight models.Flight
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockFlight(tx, flightID)
+		if err != nil {
+			return err
+		}
+		index, err := newAirportIndex(tx)
+		if err != nil {
+			return err
+		}
+		flight = priceFlight(h.Pricing, index, locked, time.Now())
+		unitPrice, err := h.Quotes.unitPrice(tx, quoteID, clientAddr, flight, seats, fareClass)
+		if err != nil {
+			return err
+		}
+		hold, err = placeHold(tx, &flight, clientAddr, fareClass, seats, unitPrice, duration)
+		if err != nil {
+			return err
+		}
+		return record(tx, events.FlightChange(flightID, models.ChangeBooking))
+	})
+	if err != nil {
+		return models.SeatHold{}, models.Flight{}, err
+	}
+	return hold, flight, nil
+}
+
+// placeHold takes seats out of a locked flight and records the hold, inside
+// the caller's transaction.
+func placeHold(tx *gorm.DB, flight *models.Flight, clientAddr, fareClass string, seats int, unitPrice models.Money, duration time.Duration) (models.SeatHold, error) {
+	if duration <= 0 {
+		duration = DefaultHoldDuration
+	}
+	if duration > MaxHoldDuration {
+		duration = MaxHoldDuration
+	}
+	token, err := newHoldToken()
+	if err != nil {
+		return models.SeatHold{}, err
+	}
+	if err := sellSeats(tx, flight, clientAddr, fareClass, seats); err != nil {
+		return models.SeatHold{}, err
+	}
+
+	hold := models.SeatHold{
+		Token:      token,
+		FlightID:   flight.ID,
+		ClientAddr: clientAddr,
+		Seats:      seats,
+		FareClass:  fareClass,
+		UnitPrice:  unitPrice,
+		Status:     models.HoldActive,
+		ExpiresAt:  time.Now().Add(duration),
+	}
+	if err := tx.Create(&hold).Error; err != nil {
+		return models.SeatHold{}, err
+	}
+	return hold, nil
+}
+
+// ConfirmHold turns an unexpired hold into a booking at the fare locked by
+// the hold. The seats were already taken out of availability when the hold
+// was placed.
+func (h *HoldServiceImpl) ConfirmHold(token, clientAddr string) (models.SeatHold, models.Booking, models.Flight, error) {
+	var hold models.SeatHold
+	var booking models.Booking
+	var flight models.Flight
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&hold, "token = ?", token).Error; err != nil {
+			return errors.New("Hold not found")
+		}
+		if hold.ClientAddr != clientAddr {
+			return errors.New("Hold belongs to another client")
+		}
+		if hold.Status != models.HoldActive {
+			return errors.New("Hold is already " + hold.Status)
+		}
+		if time.Now().After(hold.ExpiresAt) {
+			return errors.New("Hold expired")
+		}
+		if err := tx.Preload("FareClasses").First(&flight, hold.FlightID).Error; err != nil {
+			return errors.New("Flight not found")
+		}
+		hold.Status = models.HoldConfirmed
+		if err := tx.Save(&hold).Error; err != nil {
+			return err
+		}
+		var err error
+		booking, err = recordBooking(tx, clientAddr, hold.FlightID, hold.FareClass, hold.Seats, hold.UnitPrice)
+		if err != nil {
+			return err
+		}
+		return record(tx, events.BookingCreation(booking))
+	})
+	if err != nil {
+		return models.SeatHold{}, models.Booking{}, models.Flight{}, err
+	}
+	return hold, booking, flight, nil
+}
+
+// ReleaseHold cancels an unconfirmed hold and returns its seats.
+func (h *HoldServiceImpl) ReleaseHold(token, clientAddr string) (models.SeatHold, error) {
+	var hold models.SeatHold
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&hold, "token = ?", token).Error; err != nil {
+			return errors.New("Hold not found")
+		}
+		if hold.ClientAddr != clientAddr {
+			return errors.New("Hold belongs to another client")
+		}
+		if hold.Status != models.HoldActive {
+			return errors.New("Hold is already " + hold.Status)
+		}
+		return releaseHold(tx, &hold)
+	})
+	if err != nil {
+		return models.SeatHold{}, err
+	}
+	return hold, nil
+}
+
+// ReleaseExpired returns the seats of every hold that expired before now and
+// reports the released holds.
+func (h *HoldServiceImpl) ReleaseExpired(now time.Time) ([]models.SeatHold, error) {
+	var expired []models.SeatHold
+	if err := h.DB.Where("status = ? AND expires_at <= ?", models.HoldActive, now).Find(&expired).Error; err != nil {
+		return nil, err
+	}
+
+	var released []models.SeatHold
+	for _, candidate := range expired {
+		var hold models.SeatHold
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&hold, "token = ?", candidate.Token).Error; err != nil {
+				return err
+			}
+			// The hold may have been confirmed since it was read
+			if hold.Status != models.HoldActive {
+				return nil
+			}
+			return releaseHold(tx, &hold)
+		})
+		if err != nil {
+			return released, err
+		}
+		if hold.Status == models.HoldReleased {
+			released = append(released, hold)
+		}
+	}
+	return released, nil
+}
+
+func releaseHold(tx *gorm.DB, hold *models.SeatHold) error {
+	hold.Status = models.HoldReleased
+	if err := tx.Save(hold).Error; err != nil {
+		return err
+	}
+	if err := returnSeats(tx, hold.FlightID, hold.ClientAddr, hold.FareClass, hold.Seats); err != nil {
+		return err
+	}
+	return record(tx, events.FlightChange(hold.FlightID, models.ChangeCancellation))
+}
+
+func newHoldToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockFlight loads a flight and its fare classes for update inside a transaction.
+func lockFlight(tx *gorm.DB, flightID int) (models.Flight, error) {
+	var flight models.Flight
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("FareClasses").First(&flight, flightID).Error; err != nil {
+		return models.Flight{}, errors.New("Flight not found")
+	}
+	return flight, nil
+}
+
+// seatsLeft returns the seats that can be sold in a fare class, or on the
+// whole flight when no class is given. A flight sold by fare class must be
+// booked in one, or the class inventories would drift from the total.
+func seatsLeft(flight models.Flight, fareClass string) (int, error) {
+	if fareClass == "" {
+		if len(flight.FareClasses) > 0 {
+			return 0, errors.New("Fare class required")
+		}
+		return flight.SeatAvailability, nil
+	}
+	for _, class := range flight.FareClasses {
+		if class.Code == fareClass {
+			return class.SeatAvailability, nil
+		}
+	}
+	return 0, errors.New("Fare class not found")
+}
+
+// takeSeats removes seats from a locked flight and, when given, its fare
+// class, keeping the flight total in step with the class inventory.
+func takeSeats(tx *gorm.DB, flight *models.Flight, fareClass string, seats int) error {
+	if seats <= 0 {
+		return errors.New("Invalid number of seats")
+	}
+	left, err := seatsLeft(*flight, fareClass)
+	if err != nil {
+		return err
+	}
+	if left < seats || flight.SeatAvailability < seats {
+		return errors.New("Insufficient seats available")
+	}
+	for i := range flight.FareClasses {
+		class := &flight.FareClasses[i]
+		if class.Code != fareClass {
+			continue
+		}
+		class.SeatAvailability -= seats
+		if err := tx.Model(class).UpdateColumn("seat_availability", class.SeatAvailability).Error; err != nil {
+			return err
+		}
+	}
+	flight.SeatAvailability -= seats
+	return tx.Model(flight).UpdateColumn("seat_availability", flight.SeatAvailability).Error
+}
+
+// sellSeats takes seats for a booking made by count and, when the flight has
+// a seat map, assigns the client the first free seats in the cabin so the
+// map stays in step with availability.
+func sellSeats(tx *gorm.DB, flight *models.Flight, clientAddr, fareClass string, seats int) error {
+	if err := takeSeats(tx, flight, fareClass, seats); err != nil {
+		return err
+	}
+	return assignSeats(tx, flight.ID, clientAddr, fareClass, seats)
+}
+
+// assignSeats marks free seats in a cabin as the client's. Flights without a
+// seat map are left alone.
+func assignSeats(tx *gorm.DB, flightID int, clientAddr, cabin string, seats int) error {
+	var mapped int64
+	if err := tx.Model(&models.Seat{}).Where("flight_id = ?", flightID).Count(&mapped).Error; err != nil || mapped == 0 {
+		return err
+	}
+	var free []uint
+	err := tx.Model(&models.Seat{}).Where("flight_id = ? AND cabin = ? AND occupied = ?", flightID, cabin, false).
+		Order("seat_row, letter").Limit(seats).Pluck("id", &free).Error
+	if err != nil {
+		return err
+	}
+	if len(free) < seats {
+		return errors.New("Insufficient seats available")
+	}
+	return tx.Model(&models.Seat{}).Where("id IN ?", free).
+		Updates(map[string]interface{}{"occupied": true, "client_addr": clientAddr, "assigned": true}).Error
+}
+
+// freeSeats releases a client's seats in a cabin, server-assigned ones first.
+func freeSeats(tx *gorm.DB, flightID int, clientAddr, cabin string, seats int) error {
+	var taken []uint
+	err := tx.Model(&models.Seat{}).Where("flight_id = ? AND cabin = ? AND occupied = ? AND client_addr = ?", flightID, cabin, true, clientAddr).
+		Order("assigned DESC, seat_row DESC, letter DESC").Limit(seats).Pluck("id", &taken).Error
+	if err != nil || len(taken) == 0 {
+		return err
+	}
+	return tx.Model(&models.Seat{}).Where("id IN ?", taken).
+		Updates(map[string]interface{}{"occupied": false, "client_addr": "", "assigned": false}).Error
+}
+
+// returnSeats puts a client's seats back on a flight and its fare class.
+func returnSeats(tx *gorm.DB, flightID int, clientAddr, fareClass string, seats int) error {
+	if err := freeSeats(tx, flightID, clientAddr, fareClass, seats); err != nil {
+		return err
+	}
+	if fareClass != "" {
+		err := tx.Model(&models.FareClass{}).Where("flight_id = ? AND code = ?", flightID, fareClass).
+			UpdateColumn("seat_availability", gorm.Expr("seat_availability + ?", seats)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.Flight{}).Where("id = ?", flightID).
+		UpdateColumn("seat_availability", gorm.Expr("seat_availability + ?", seats)).Error
+}
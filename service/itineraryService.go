@@ -0,0 +1,241 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultMaxStops          = 1
+	MaxAllowedStops          = 3
+	DefaultMinLayover        = 45 * time.Minute
+	DefaultMaxLayover        = 12 * time.Hour
+	DefaultItineraryPageSize = 2
+	MaxItineraryPageSize     = 10 // Replies are trimmed further to fit a datagram
+)
+
+type ItineraryService interface {
+	SearchItineraries(query models.ItineraryQuery) (models.ItineraryPage, error)
+	BookItinerary(clientAddr string, flightIDs []int, seats int, fareClass string) ([]models.Booking, []models.Flight, error)
+}
+
+type ItineraryServiceImpl struct {
+	DB      *gorm.DB
+	Pricing pricing.Engine
+}
+
+// SearchItineraries returns one page of direct and connecting itineraries
+// between source and destination.
+func (s *ItineraryServiceImpl) SearchItineraries(query models.ItineraryQuery) (models.ItineraryPage, error) {
+	query = withItineraryDefaults(query)
+	offset, err := DecodeCursor(query.Cursor)
+	if err != nil {
+		return models.ItineraryPage{}, err
+	}
+
+	// Seat and carrier filters apply to every leg; price and departure
+	// filters apply to the itinerary as a whole.
+	legFilters := models.SearchFilters{MinSeats: query.MinSeats, Carrier: query.Carrier}
+	var flights []models.Flight
+	if err := applySearchFilters(s.DB, legFilters).Preload("FareClasses").Find(&flights).Error; err != nil {
+		return models.ItineraryPage{}, err
+	}
+	index, err := newAirportIndex(s.DB)
+	if err != nil {
+		return models.ItineraryPage{}, err
+	}
+	priceFlights(s.Pricing, index, flights, time.Now())
+	source, destination := index.key(query.Source), index.key(query.Destination)
+
+	// Build the route graph: every airport points to the flights leaving it
+	routes := make(map[string][]models.Flight)
+	for _, flight := range flights {
+		from := index.key(flight.Source)
+		routes[from] = append(routes[from], flight)
+	}
+
+	var itineraries []models.Itinerary
+	visited := map[string]bool{source: true}
+	var walk func(legs []models.Flight)
+	walk = func(legs []models.Flight) {
+		last := legs[len(legs)-1]
+		arrivedAt := index.key(last.Destination)
+		if arrivedAt == destination {
+			if itinerary := newItinerary(index, legs); matchesItineraryFilters(index, itinerary, query.SearchFilters) {
+				itineraries = append(itineraries, itinerary)
+			}
+			return
+		}
+		if len(legs) > query.MaxStops || visited[arrivedAt] {
+			return
+		}
+		visited[arrivedAt] = true
+		for _, next := range routes[arrivedAt] {
+			if visited[index.key(next.Destination)] || !validConnection(index, last, next, query.MinLayover, query.MaxLayover) {
+				continue
+			}
+			walk(append(legs[:len(legs):len(legs)], next))
+		}
+		visited[arrivedAt] = false
+	}
+	for _, first := range routes[source] {
+		walk([]models.Flight{first})
+	}
+
+	sortItineraries(index, itineraries, query.SortBy)
+	pageSize := clampPageSize(query.PageSize, DefaultItineraryPageSize, MaxItineraryPageSize)
+	page, next := paginate(itineraries, offset, pageSize)
+	return models.ItineraryPage{Itineraries: page, NextCursor: next, Offset: offset}, nil
+}
+
+// BookItinerary reserves seats on every leg of an itinerary, or on none of
+// them, recording a booking per leg. A fare class, when given, applies to
+// every leg. Connections must meet the default layover bounds used by search.
+func (s *ItineraryServiceImpl) BookItinerary(clientAddr string, flightIDs []int, seats int, fareClass string) ([]models.Booking, []models.Flight, error) {
+	if len(flightIDs) == 0 {
+		return nil, nil, errors.New("Itinerary has no flights")
+	}
+	if seats <= 0 {
+		return nil, nil, errors.New("Invalid number of seats")
+	}
+
+	index, err := newAirportIndex(s.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bookings []models.Booking
+	var legs []models.Flight
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for i, flightID := range flightIDs {
+			locked, err := lockFlight(tx, flightID)
+			if err != nil {
+				return err
+			}
+			if i > 0 && !validConnection(index, legs[i-1], locked, DefaultMinLayover, DefaultMaxLayover) {
+				return errors.New("Flights do not form a valid itinerary")
+			}
+			flight := priceFlight(s.Pricing, index, locked, now)
+			unitPrice, _, err := flight.Fare(fareClass)
+			if err != nil {
+				return err
+			}
+			if err := sellSeats(tx, &flight, clientAddr, fareClass, seats); err != nil {
+				return err
+			}
+			booking, err := recordBooking(tx, clientAddr, flightID, fareClass, seats, unitPrice)
+			if err != nil {
+				return err
+			}
+			bookings = append(bookings, booking)
+			legs = append(legs, flight)
+			if err := record(tx, events.FlightChange(flightID, models.ChangeBooking), events.BookingCreation(booking)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return bookings, legs, nil
+}
+
+func withItineraryDefaults(query models.ItineraryQuery) models.ItineraryQuery {
+	if query.MinSeats <= 0 {
+		query.MinSeats = 1
+	}
+	if query.MaxStops <= 0 {
+		query.MaxStops = DefaultMaxStops
+	}
+	if query.MaxStops > MaxAllowedStops {
+		query.MaxStops = MaxAllowedStops
+	}
+	if query.MinLayover <= 0 {
+		query.MinLayover = DefaultMinLayover
+	}
+	if query.MaxLayover <= 0 {
+		query.MaxLayover = DefaultMaxLayover
+	}
+	return query
+}
+
+// validConnection reports whether next can be taken after arriving on prev.
+// Both times are read in their airports' zones, so the layover is real time.
+// A zero maxLayover means no upper bound.
+func validConnection(index airportIndex, prev, next models.Flight, minLayover, maxLayover time.Duration) bool {
+	if index.key(prev.Destination) != index.key(next.Source) {
+		return false
+	}
+	arrival, err := index.arrival(prev)
+	if err != nil {
+		return false
+	}
+	departure, err := index.departure(next)
+	if err != nil {
+		return false
+	}
+	layover := departure.Sub(arrival)
+	if layover < minLayover {
+		return false
+	}
+	return maxLayover == 0 || layover <= maxLayover
+}
+
+func newItinerary(index airportIndex, legs []models.Flight) models.Itinerary {
+	itinerary := models.Itinerary{Legs: legs}
+	for _, leg := range legs {
+		itinerary.TotalFare += leg.Airfare
+	}
+	departure, err := index.departure(legs[0])
+	if err != nil {
+		return itinerary
+	}
+	arrival, err := index.arrival(legs[len(legs)-1])
+	if err != nil {
+		return itinerary
+	}
+	itinerary.TotalDuration = arrival.Sub(departure)
+	return itinerary
+}
+
+func matchesItineraryFilters(index airportIndex, itinerary models.Itinerary, filters models.SearchFilters) bool {
+	return matchesPrice(itinerary.TotalFare, filters) && departsWithin(index, itinerary.Legs[0], filters)
+}
+
+// sortItineraries orders itineraries by total fare, departure or duration,
+// with ties broken by fare and then by fewer legs. Departures are compared
+// in the zone of each first leg's airport.
+func sortItineraries(index airportIndex, itineraries []models.Itinerary, sortBy string) {
+	departure := func(itinerary models.Itinerary) (int64, bool) {
+		t, err := index.departure(itinerary.Legs[0])
+		return t.UnixNano(), err == nil
+	}
+	sort.SliceStable(itineraries, func(i, j int) bool {
+		a, b := itineraries[i], itineraries[j]
+		if sortBy == "departure" {
+			da, okA := departure(a)
+			db, okB := departure(b)
+			if okA != okB {
+				return okA
+			}
+			if da != db {
+				return da < db
+			}
+		}
+		if sortBy == "duration" && a.TotalDuration != b.TotalDuration {
+			return a.TotalDuration < b.TotalDuration
+		}
+		if a.TotalFare != b.TotalFare {
+			return a.TotalFare < b.TotalFare
+		}
+		return len(a.Legs) < len(b.Legs)
+	})
+}
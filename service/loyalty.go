@@ -0,0 +1,141 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+)
+
+// RedemptionCost returns the points a client would pay for a price after
+// their tier discount.
+func (p *PointsServiceImpl) RedemptionCost(clientAddr string, price models.Money) (models.Money, error) {
+	var clientPoints models.ClientPoints
+	err := p.DB.Where("client_addr = ?", clientAddr).Limit(1).Find(&clientPoints).Error
+	if err != nil {
+		return 0, err
+	}
+	return discounted(price, p.Loyalty.Tier(clientPoints.Tier)), nil
+}
+
+// TierStatus returns a client's current tier and their activity within the
+// qualifying window.
+func (p *PointsServiceImpl) TierStatus(clientAddr string) (models.TierStatus, error) {
+	var clientPoints models.ClientPoints
+	if err := p.DB.Where("client_addr = ?", clientAddr).Limit(1).Find(&clientPoints).Error; err != nil {
+		return models.TierStatus{}, err
+	}
+	status, err := p.qualify(clientAddr, p.now())
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	status.Tier = p.Loyalty.Tier(clientPoints.Tier)
+	status.Next = p.Loyalty.Next(status.Tier.Name)
+	return status, nil
+}
+
+// Requalify moves a client to the tier their activity within the window
+// earns, upgrading or downgrading as needed.
+func (p *PointsServiceImpl) Requalify(clientAddr string) (models.TierStatus, error) {
+	return p.review(clientAddr, true)
+}
+
+// RequalifyAll reviews every client's tier and returns how many changed.
+func (p *PointsServiceImpl) RequalifyAll() (int, error) {
+	var clients []string
+	if err := p.DB.Model(&models.ClientPoints{}).Pluck("client_addr", &clients).Error; err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, clientAddr := range clients {
+		before, err := p.TierStatus(clientAddr)
+		if err != nil {
+			return changed, err
+		}
+		after, err := p.Requalify(clientAddr)
+		if err != nil {
+			return changed, err
+		}
+		if after.Tier.Name != before.Tier.Name {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// upgrade moves a client up a tier as soon as they qualify; downgrades wait
+// for the periodic review.
+func (p *PointsServiceImpl) upgrade(clientAddr string) (models.TierStatus, error) {
+	return p.review(clientAddr, false)
+}
+
+func (p *PointsServiceImpl) review(clientAddr string, allowDowngrade bool) (models.TierStatus, error) {
+	var status models.TierStatus
+	err := p.DB.Transaction(func(tx *gorm.DB) error {
+		clientPoints, err := p.lockPoints(tx, clientAddr)
+		if err != nil {
+			return err
+		}
+		status, err = p.qualify(clientAddr, p.now())
+		if err != nil {
+			return err
+		}
+		if !allowDowngrade && tierRank(p.Loyalty, status.Tier.Name) < tierRank(p.Loyalty, clientPoints.Tier) {
+			status.Tier = p.Loyalty.Tier(clientPoints.Tier)
+			status.Next = p.Loyalty.Next(status.Tier.Name)
+			return nil
+		}
+		if status.Tier.Name == clientPoints.Tier {
+			return nil
+		}
+		return tx.Model(&clientPoints).UpdateColumn("tier", status.Tier.Name).Error
+	})
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	return status, nil
+}
+
+// qualify works out the tier a client's points earned and flights booked
+// within the programme window reach.
+func (p *PointsServiceImpl) qualify(clientAddr string, now time.Time) (models.TierStatus, error) {
+	var status models.TierStatus
+	if p.Loyalty == nil {
+		status.Tier = p.Loyalty.Qualify(0, 0)
+		return status, nil
+	}
+	since := now.Add(-p.Loyalty.Window)
+
+	err := p.DB.Model(&models.PointsEntry{}).
+		Where("client_addr = ? AND type = ? AND created_at >= ?", clientAddr, models.PointsEarn, since).
+		Select("COALESCE(SUM(amount), 0)").Scan(&status.QualifyingPoints).Error
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	var segments int64
+	err = p.DB.Model(&models.Booking{}).
+		Where("client_addr = ? AND created_at >= ?", clientAddr, since).Count(&segments).Error
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	status.Segments = int(segments)
+	status.Tier = p.Loyalty.Qualify(status.QualifyingPoints, status.Segments)
+	status.Next = p.Loyalty.Next(status.Tier.Name)
+	return status, nil
+}
+
+func tierRank(program *models.LoyaltyProgram, name string) int {
+	if program == nil {
+		return 0
+	}
+	for i, tier := range program.Tiers {
+		if tier.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+func discounted(price models.Money, tier models.Tier) models.Money {
+	return price.Scale(1 - tier.RedemptionDiscount)
+}
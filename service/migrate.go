@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+)
+
+// moneyColumns lists every column holding a fare or points amount.
+var moneyColumns = []struct {
+	model   interface{}
+	columns []string
+}{
+	{&models.Flight{}, []string{"airfare"}},
+	{&models.FareClass{}, []string{"fare"}},
+	{&models.ClientPoints{}, []string{"points"}},
+	{&models.PointsEntry{}, []string{"amount", "balance"}},
+	{&models.Booking{}, []string{"unit_price", "total_price"}},
+	{&models.SeatHold{}, []string{"unit_price"}},
+	{&models.Quote{}, []string{"unit_price", "total_price"}},
+}
+
+// MigrateMoneyColumns converts amount columns still stored as floating point
+// whole units into integer minor units. It must run before AutoMigrate, which
+// would otherwise change the column type without rescaling the values.
+//
+// MySQL commits each DDL statement on its own, so a column is converted in
+// steps that are each safe to repeat: the rescaled values are written to a
+// new BIGINT column, recomputed from the untouched original on every run, and
+// one ALTER then drops the original and renames the copy in its place.
+// Columns that are already integers are left alone, so a run interrupted at
+// any point can simply be started again.
+func MigrateMoneyColumns(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, table := range moneyColumns {
+		if !migrator.HasTable(table.model) {
+			continue
+		}
+		columnTypes, err := migrator.ColumnTypes(table.model)
+		if err != nil {
+			return err
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table.model); err != nil {
+			return err
+		}
+		for _, columnType := range columnTypes {
+			if !contains(table.columns, columnType.Name()) || !isFractional(columnType.DatabaseTypeName()) {
+				continue
+			}
+			if err := convertMoneyColumn(db, stmt.Schema.Table, columnType.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// staleIndexes lists indexes earlier versions created that AutoMigrate does
+// not remove by itself.
+var staleIndexes = []struct {
+	model interface{}
+	name  string
+}{
+	{&models.Transfer{}, "idx_transfers_idempotency_key"}, // Now unique per sender
+}
+
+// DropStaleIndexes removes indexes that no longer match the models. It is
+// safe to run on every start.
+func DropStaleIndexes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, index := range staleIndexes {
+		if !migrator.HasTable(index.model) || !migrator.HasIndex(index.model, index.name) {
+			continue
+		}
+		if err := migrator.DropIndex(index.model, index.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertMoneyColumn(db *gorm.DB, table, column string) error {
+	name := table + "." + column
+	minor := column + "_minor"
+	if !db.Migrator().HasColumn(table, minor) {
+		add := fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` BIGINT NOT NULL DEFAULT 0", table, minor)
+		if err := db.Exec(add).Error; err != nil {
+			return fmt.Errorf("adding %s.%s: %w", table, minor, err)
+		}
+	}
+	update := fmt.Sprintf("UPDATE `%s` SET `%s` = ROUND(`%s` * %d)", table, minor, column, models.MoneyScale)
+	if err := db.Exec(update).Error; err != nil {
+		return fmt.Errorf("rescaling %s: %w", name, err)
+	}
+	swap := fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`, CHANGE `%s` `%s` BIGINT NOT NULL DEFAULT 0", table, column, minor, column)
+	if err := db.Exec(swap).Error; err != nil {
+		return fmt.Errorf("converting %s: %w", name, err)
+	}
+	return nil
+}
+
+func isFractional(databaseType string) bool {
+	switch strings.ToLower(databaseType) {
+	case "double", "float", "real", "decimal", "numeric":
+		return true
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MonitorService stores monitor registrations so they can be reloaded when
+// the server restarts. The in-memory registry stays the source of truth
+// while the server runs.
+type MonitorService interface {
+	Save(registration models.MonitorRegistration) error
+	Delete(clientAddr, subject string) error
+	Load() ([]models.MonitorRegistration, error)
+	DeleteExpired(now time.Time) error
+}
+
+type MonitorServiceImpl struct {
+	DB *gorm.DB
+}
+
+// Save stores a registration, replacing the client's earlier one for the
+// same subject.
+func (m *MonitorServiceImpl) Save(registration models.MonitorRegistration) error {
+	return m.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "client_addr"}, {Name: "subject"}},
+		UpdateAll: true,
+	}).Create(&registration).Error
+}
+
+func (m *MonitorServiceImpl) Delete(clientAddr, subject string) error {
+	return m.DB.Where("client_addr = ? AND subject = ?", clientAddr, subject).
+		Delete(&models.MonitorRegistration{}).Error
+}
+
+// Load returns every stored registration, expired or not.
+func (m *MonitorServiceImpl) Load() ([]models.MonitorRegistration, error) {
+	var registrations []models.MonitorRegistration
+	if err := m.DB.Order("id").Find(&registrations).Error; err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+func (m *MonitorServiceImpl) DeleteExpired(now time.Time) error {
+	return m.DB.Where("expiry <= ?", now).Delete(&models.MonitorRegistration{}).Error
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultDispatchBatch    = 100
+	DefaultDispatchAttempts = 10
+)
+
+type OutboxService interface {
+	Dispatch(limit int) (int, error)
+	Purge(before time.Time) (int64, error)
+}
+
+// OutboxServiceImpl delivers outbox events to the bus in the order they
+// were written.
+type OutboxServiceImpl struct {
+	DB          *gorm.DB
+	Events      *events.Bus
+	MaxAttempts int // Attempts before an event is marked failed; zero uses the default
+}
+
+// Dispatch publishes up to limit undelivered events and marks them
+// delivered. Delivery is at least once: an event published but not yet
+// marked when the server stops is published again, with the same key. When
+// a subscriber fails the event stays undelivered with its attempts counted,
+// and the batch stops there so later events are not delivered before it.
+// After MaxAttempts the event is marked failed and logged, and the events
+// behind it go ahead.
+func (o *OutboxServiceImpl) Dispatch(limit int) (int, error) {
+	if limit <= 0 {
+		limit = DefaultDispatchBatch
+	}
+	var pending []models.OutboxEvent
+	if err := o.DB.Where("dispatched_at IS NULL AND failed_at IS NULL").Order("id").Limit(limit).Find(&pending).Error; err != nil {
+		return 0, err
+	}
+	dispatched := 0
+	for _, row := range pending {
+		var event events.Event
+		if err := json.Unmarshal([]byte(row.Payload), &event); err != nil {
+			// A payload that cannot be read will never deliver; do not let it block the rest
+			fmt.Println("Dropping unreadable event", row.DedupKey+":", err)
+		} else if err := o.Events.Publish(event); err != nil {
+			updates := map[string]interface{}{
+				"attempts":   row.Attempts + 1,
+				"last_error": truncate(err.Error(), 500),
+			}
+			giveUp := row.Attempts+1 >= o.maxAttempts()
+			if giveUp {
+				now := time.Now()
+				updates["failed_at"] = &now
+			}
+			if updateErr := o.DB.Model(&row).Updates(updates).Error; updateErr != nil {
+				return dispatched, updateErr
+			}
+			if !giveUp {
+				return dispatched, fmt.Errorf("delivering event %s: %w", row.DedupKey, err)
+			}
+			fmt.Printf("Giving up on event %s after %d attempts: %v\n", row.DedupKey, row.Attempts+1, err)
+			continue
+		}
+		now := time.Now()
+		err := o.DB.Model(&row).Updates(map[string]interface{}{
+			"dispatched_at": &now,
+			"attempts":      row.Attempts + 1,
+		}).Error
+		if err != nil {
+			return dispatched, err
+		}
+		dispatched++
+	}
+	return dispatched, nil
+}
+
+func (o *OutboxServiceImpl) maxAttempts() int {
+	if o.MaxAttempts <= 0 {
+		return DefaultDispatchAttempts
+	}
+	return o.MaxAttempts
+}
+
+// Purge deletes events delivered before the given time. Failed events are
+// kept for inspection.
+func (o *OutboxServiceImpl) Purge(before time.Time) (int64, error) {
+	result := o.DB.Where("dispatched_at IS NOT NULL AND dispatched_at < ?", before).Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// record writes events to the outbox inside the caller's transaction, so
+// they are delivered only if it commits.
+func record(tx *gorm.DB, pending ...events.Event) error {
+	for _, event := range pending {
+		if event.Key == "" {
+			key, err := newEventKey()
+			if err != nil {
+				return err
+			}
+			event.Key = key
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		row := models.OutboxEvent{DedupKey: event.Key, Type: event.Type, Payload: string(payload)}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newEventKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 4
+	MaxPageSize     = 20 // Replies are trimmed further to fit a datagram
+)
+
+// EncodeCursor turns a result offset into an opaque continuation token.
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// DecodeCursor returns the result offset for a continuation token. An empty
+// token is the first page.
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("Invalid page cursor")
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errors.New("Invalid page cursor")
+	}
+	return offset, nil
+}
+
+func clampPageSize(pageSize, defaultSize, maxSize int) int {
+	if pageSize <= 0 {
+		return defaultSize
+	}
+	if pageSize > maxSize {
+		return maxSize
+	}
+	return pageSize
+}
+
+// paginate returns one page of an in-memory result set and the token for the next page.
+func paginate[T any](items []T, offset, pageSize int) ([]T, string) {
+	if offset >= len(items) {
+		return nil, ""
+	}
+	end := offset + pageSize
+	if end >= len(items) {
+		return items[offset:], ""
+	}
+	return items[offset:end], EncodeCursor(end)
+}
@@ -2,18 +2,48 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/Guesstrain/airline/clock"
+	"github.com/Guesstrain/airline/events"
 	"github.com/Guesstrain/airline/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultStatementPageSize = 6
+	MaxStatementPageSize     = 20 // Replies are trimmed further to fit a datagram
+	DefaultPointsLifetime    = 18 * 30 * 24 * time.Hour
+	DefaultExpiryWindow      = 90 * 24 * time.Hour
+	MaxExpiringLots          = 50 // Replies are trimmed further to fit a datagram
+	DefaultTransferLimit     = 20000 * models.MoneyScale
 )
 
 type PointsService interface {
 	QueryPoints(clientAddr string) (models.ClientPoints, error)
-	UpdatePoints(clientAddr string, points float64) (float64, error)
+	UpdatePoints(clientAddr string, points models.Money) (models.Money, error)
+	Earn(clientAddr string, points models.Money, bookingID uint, description string) (models.ClientPoints, error)
+	Redeem(clientAddr string, points models.Money, bookingID uint, description string) (models.ClientPoints, error)
+	Refund(clientAddr string, points models.Money, bookingID uint, description string) (models.ClientPoints, error)
+	Statement(clientAddr, cursor string, pageSize int) (models.PointsStatement, error)
+	RedemptionCost(clientAddr string, price models.Money) (models.Money, error)
+	TierStatus(clientAddr string) (models.TierStatus, error)
+	Requalify(clientAddr string) (models.TierStatus, error)
+	RequalifyAll() (int, error)
+	ExpirePoints() ([]models.PointsEntry, error)
+	ExpiringPoints(clientAddr string, within time.Duration) ([]models.PointsLot, models.Money, error)
+	Transfer(sender, recipient string, amount models.Money, idempotencyKey string) (models.Transfer, models.ClientPoints, error)
 }
 
 type PointsServiceImpl struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Loyalty  *models.LoyaltyProgram // Nil runs without tiers
+	Lifetime time.Duration          // How long earned points last; zero never expires them
+	Clock    clock.Clock            // Nil uses the wall clock
+
+	TransferLimit models.Money // Most points a client may send per day; zero is unlimited
 }
 
 func (p *PointsServiceImpl) QueryPoints(clientAddr string) (models.ClientPoints, error) {
@@ -27,29 +57,287 @@ func (p *PointsServiceImpl) QueryPoints(clientAddr string) (models.ClientPoints,
 	return clientPoints, nil
 }
 
-func (p *PointsServiceImpl) UpdatePoints(clientAddr string, points float64) (float64, error) {
+// UpdatePoints sets a balance directly. The difference is recorded as an
+// adjustment so the ledger still explains the balance.
+func (p *PointsServiceImpl) UpdatePoints(clientAddr string, points models.Money) (models.Money, error) {
 	var clientPoints models.ClientPoints
-
-	// Try to find existing points record
-	if err := p.DB.First(&clientPoints, "client_addr = ?", clientAddr).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// Record does not exist, create a new one
-			clientPoints = models.ClientPoints{
-				ClientAddr: clientAddr,
-				Points:     points,
-			}
-			if err := p.DB.Create(&clientPoints).Error; err != nil {
-				return 0, err
-			}
-			return points, nil
+	err := p.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := p.lockPoints(tx, clientAddr)
+		if err != nil {
+			return err
 		}
+		clientPoints, err = p.postPoints(tx, current, models.PointsAdjustment, points-current.Points, nil, "Balance set to new value")
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
+	return clientPoints.Points, nil
+}
 
-	// Record exists, update points
-	clientPoints.Points = points
-	if err := p.DB.Save(&clientPoints).Error; err != nil {
-		return 0, err
+// Earn credits points for a booking, boosted by the client's tier
+// multiplier. Reaching a higher tier upgrades the client straight away.
+func (p *PointsServiceImpl) Earn(clientAddr string, points models.Money, bookingID uint, description string) (models.ClientPoints, error) {
+	clientPoints, err := p.post(clientAddr, models.PointsEarn, func(tier models.Tier) models.Money {
+		return points.Scale(tier.EarnMultiplier)
+	}, bookingID, description)
+	if err != nil {
+		return models.ClientPoints{}, err
 	}
-	return clientPoints.Points, nil
+	if status, err := p.upgrade(clientAddr); err == nil {
+		clientPoints.Tier = status.Tier.Name
+	}
+	return clientPoints, nil
+}
+
+// Redeem debits the points price of a booking less the client's tier
+// discount, failing when the balance is too low.
+func (p *PointsServiceImpl) Redeem(clientAddr string, price models.Money, bookingID uint, description string) (models.ClientPoints, error) {
+	return p.post(clientAddr, models.PointsRedeem, func(tier models.Tier) models.Money {
+		return -discounted(price, tier)
+	}, bookingID, description)
+}
+
+// payBooking pays the covered part of a booking's price with points, less
+// the client's tier discount, and records the cash and points split on the
+// booking, inside the caller's transaction. Covering the whole price pays
+// for the booking entirely in points.
+func (p *PointsServiceImpl) payBooking(tx *gorm.DB, clientAddr string, booking models.Booking, covered models.Money) (models.Booking, error) {
+	if covered <= 0 {
+		return models.Booking{}, errors.New("Invalid number of points")
+	}
+	if covered > booking.TotalPrice {
+		covered = booking.TotalPrice
+	}
+	current, err := p.lockPoints(tx, clientAddr)
+	if err != nil {
+		return models.Booking{}, err
+	}
+	cost := discounted(covered, p.Loyalty.Tier(current.Tier))
+	description := fmt.Sprintf("Flight %d", booking.FlightID)
+	if _, err := p.postPoints(tx, current, models.PointsRedeem, -cost, &booking.ID, description); err != nil {
+		return models.Booking{}, err
+	}
+	booking.PaidPoints = cost
+	booking.PaidCash = booking.TotalPrice - covered
+	err = tx.Model(&booking).Updates(map[string]interface{}{
+		"paid_points": booking.PaidPoints,
+		"paid_cash":   booking.PaidCash,
+	}).Error
+	if err != nil {
+		return models.Booking{}, err
+	}
+	return booking, nil
+}
+
+// Refund credits back points redeemed for a booking.
+func (p *PointsServiceImpl) Refund(clientAddr string, points models.Money, bookingID uint, description string) (models.ClientPoints, error) {
+	return p.post(clientAddr, models.PointsRefund, func(models.Tier) models.Money {
+		return points
+	}, bookingID, description)
+}
+
+// Statement returns one page of a client's ledger, newest first, with the
+// stored balance and the balance the ledger adds up to.
+func (p *PointsServiceImpl) Statement(clientAddr, cursor string, pageSize int) (models.PointsStatement, error) {
+	offset, err := DecodeCursor(cursor)
+	if err != nil {
+		return models.PointsStatement{}, err
+	}
+	pageSize = clampPageSize(pageSize, DefaultStatementPageSize, MaxStatementPageSize)
+
+	var statement models.PointsStatement
+	if clientPoints, err := p.QueryPoints(clientAddr); err == nil {
+		statement.Balance = clientPoints.Points
+	}
+	err = p.DB.Model(&models.PointsEntry{}).Where("client_addr = ?", clientAddr).
+		Select("COALESCE(SUM(amount), 0)").Scan(&statement.LedgerBalance).Error
+	if err != nil {
+		return models.PointsStatement{}, err
+	}
+
+	var entries []models.PointsEntry
+	err = p.DB.Where("client_addr = ?", clientAddr).Order("id DESC").
+		Offset(offset).Limit(pageSize + 1).Find(&entries).Error
+	if err != nil {
+		return models.PointsStatement{}, err
+	}
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		statement.NextCursor = EncodeCursor(offset + pageSize)
+	}
+	statement.Entries = entries
+	statement.Offset = offset
+	return statement, nil
+}
+
+// post applies one ledger entry. The amount is worked out from the client's
+// tier once their balance is locked.
+func (p *PointsServiceImpl) post(clientAddr, entryType string, amount func(models.Tier) models.Money, bookingID uint, description string) (models.ClientPoints, error) {
+	var booking *uint
+	if bookingID != 0 {
+		booking = &bookingID
+	}
+	var clientPoints models.ClientPoints
+	err := p.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := p.lockPoints(tx, clientAddr)
+		if err != nil {
+			return err
+		}
+		clientPoints, err = p.postPoints(tx, current, entryType, amount(p.Loyalty.Tier(current.Tier)), booking, description)
+		return err
+	})
+	if err != nil {
+		return models.ClientPoints{}, err
+	}
+	return clientPoints, nil
+}
+
+func (p *PointsServiceImpl) now() time.Time {
+	if p.Clock == nil {
+		return time.Now()
+	}
+	return p.Clock.Now()
+}
+
+// lockPoints loads a client's balance for update, creating an empty one if
+// needed. A balance from before the ledger existed gets an opening entry so
+// the ledger adds up to it, and any balance not yet tracked by lots gets a
+// lot so it can expire.
+func (p *PointsServiceImpl) lockPoints(tx *gorm.DB, clientAddr string) (models.ClientPoints, error) {
+	var clientPoints models.ClientPoints
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&clientPoints, "client_addr = ?", clientAddr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		clientPoints = models.ClientPoints{ClientAddr: clientAddr}
+		return clientPoints, tx.Create(&clientPoints).Error
+	}
+	if err != nil {
+		return models.ClientPoints{}, err
+	}
+
+	var entries int64
+	if err := tx.Model(&models.PointsEntry{}).Where("client_addr = ?", clientAddr).Count(&entries).Error; err != nil {
+		return models.ClientPoints{}, err
+	}
+	if entries == 0 && clientPoints.Points != 0 {
+		opening := models.PointsEntry{
+			ClientAddr:  clientAddr,
+			Type:        models.PointsAdjustment,
+			Amount:      clientPoints.Points,
+			Balance:     clientPoints.Points,
+			Description: "Opening balance",
+			CreatedAt:   p.now(),
+		}
+		if err := tx.Create(&opening).Error; err != nil {
+			return models.ClientPoints{}, err
+		}
+	}
+
+	var tracked models.Money
+	err = tx.Model(&models.PointsLot{}).Where("client_addr = ?", clientAddr).
+		Select("COALESCE(SUM(remaining), 0)").Scan(&tracked).Error
+	if err != nil {
+		return models.ClientPoints{}, err
+	}
+	if clientPoints.Points > tracked {
+		if err := p.addLot(tx, clientAddr, 0, clientPoints.Points-tracked); err != nil {
+			return models.ClientPoints{}, err
+		}
+	}
+	return clientPoints, nil
+}
+
+// postPoints applies a signed amount to a locked balance and appends the
+// ledger entry, inside the caller's transaction. Credits open a new lot and
+// debits use up existing ones; expiry entries have already emptied theirs.
+func (p *PointsServiceImpl) postPoints(tx *gorm.DB, clientPoints models.ClientPoints, entryType string, amount models.Money, bookingID *uint, description string) (models.ClientPoints, error) {
+	clientPoints, entry, err := p.postEntry(tx, clientPoints, entryType, amount, bookingID, description)
+	if err != nil {
+		return models.ClientPoints{}, err
+	}
+	switch {
+	case amount > 0:
+		err := p.addLot(tx, clientPoints.ClientAddr, entry.ID, amount)
+		if err != nil {
+			return models.ClientPoints{}, err
+		}
+	case amount < 0 && entryType != models.PointsExpiry:
+		if _, err := consumeLots(tx, clientPoints.ClientAddr, -amount); err != nil {
+			return models.ClientPoints{}, err
+		}
+	}
+	return clientPoints, nil
+}
+
+// postEntry applies a signed amount to a locked balance and appends the
+// ledger entry, leaving the lots to the caller.
+func (p *PointsServiceImpl) postEntry(tx *gorm.DB, clientPoints models.ClientPoints, entryType string, amount models.Money, bookingID *uint, description string) (models.ClientPoints, models.PointsEntry, error) {
+	if amount < 0 && clientPoints.Points+amount < 0 && entryType != models.PointsAdjustment {
+		return models.ClientPoints{}, models.PointsEntry{}, errors.New("Not Enough Points")
+	}
+	clientPoints.Points += amount
+	if err := tx.Save(&clientPoints).Error; err != nil {
+		return models.ClientPoints{}, models.PointsEntry{}, err
+	}
+	entry := models.PointsEntry{
+		ClientAddr:  clientPoints.ClientAddr,
+		Type:        entryType,
+		Amount:      amount,
+		Balance:     clientPoints.Points,
+		BookingID:   bookingID,
+		Description: description,
+		CreatedAt:   p.now(),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return models.ClientPoints{}, models.PointsEntry{}, err
+	}
+	if err := record(tx, events.PointsChange(entry)); err != nil {
+		return models.ClientPoints{}, models.PointsEntry{}, err
+	}
+	return clientPoints, entry, nil
+}
+
+func (p *PointsServiceImpl) addLot(tx *gorm.DB, clientAddr string, entryID uint, amount models.Money) error {
+	now := p.now()
+	lot := models.PointsLot{
+		ClientAddr: clientAddr,
+		EntryID:    entryID,
+		Amount:     amount,
+		Remaining:  amount,
+		EarnedAt:   now,
+	}
+	if p.Lifetime > 0 {
+		expiresAt := now.Add(p.Lifetime)
+		lot.ExpiresAt = &expiresAt
+	}
+	return tx.Create(&lot).Error
+}
+
+// consumeLots uses up amount from a client's lots, soonest to expire first,
+// and returns what was taken from each lot as Amount.
+func consumeLots(tx *gorm.DB, clientAddr string, amount models.Money) ([]models.PointsLot, error) {
+	var lots []models.PointsLot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("client_addr = ? AND remaining > 0", clientAddr).
+		Order("expires_at IS NULL, expires_at, id").Find(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+	var consumed []models.PointsLot
+	for _, lot := range lots {
+		if amount <= 0 {
+			break
+		}
+		used := lot.Remaining
+		if used > amount {
+			used = amount
+		}
+		if err := tx.Model(&lot).UpdateColumn("remaining", lot.Remaining-used).Error; err != nil {
+			return nil, err
+		}
+		lot.Amount, lot.Remaining = used, lot.Remaining-used
+		consumed = append(consumed, lot)
+		amount -= used
+	}
+	return consumed, nil
 }
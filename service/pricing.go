@@ -0,0 +1,63 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+)
+
+// priceFlight returns a copy of a flight with its stored base fares replaced
+// by the fares the engine quotes now. A nil engine charges the base fares.
+// Only seat columns are ever written back, so the base fares in the database
+// are never overwritten. The departure is read in the source airport's zone.
+func priceFlight(engine pricing.Engine, index airportIndex, flight models.Flight, now time.Time) models.Flight {
+	priced := flight
+	priced.FareClasses = append([]models.FareClass(nil), flight.FareClasses...)
+	if engine == nil {
+		return priced
+	}
+	departure, _ := index.departure(flight)
+	priced.Airfare = engine.Price(pricing.Input{
+		BaseFare:  flight.Airfare,
+		Capacity:  flight.Capacity,
+		Available: flight.SeatAvailability,
+		Departure: departure,
+		Now:       now,
+	})
+	for i, class := range flight.FareClasses {
+		priced.FareClasses[i].Fare = engine.Price(pricing.Input{
+			BaseFare:  class.Fare,
+			Capacity:  class.Capacity,
+			Available: class.SeatAvailability,
+			Departure: departure,
+			Now:       now,
+		})
+	}
+	return priced
+}
+
+func priceFlights(engine pricing.Engine, index airportIndex, flights []models.Flight, now time.Time) {
+	for i := range flights {
+		flights[i] = priceFlight(engine, index, flights[i], now)
+	}
+}
+
+// recordBooking stores the reservation and the unit price charged, inside
+// the caller's transaction.
+func recordBooking(tx *gorm.DB, clientAddr string, flightID int, fareClass string, seats int, unitPrice models.Money) (models.Booking, error) {
+	booking := models.Booking{
+		ClientAddr: clientAddr,
+		FlightID:   flightID,
+		FareClass:  fareClass,
+		Seats:      seats,
+		UnitPrice:  unitPrice,
+		TotalPrice: unitPrice.Times(seats),
+		PaidCash:   unitPrice.Times(seats),
+	}
+	if err := tx.Create(&booking).Error; err != nil {
+		return models.Booking{}, err
+	}
+	return booking, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultQuoteValidity = 10 * time.Minute
+	MaxQuoteValidity     = 30 * time.Minute
+)
+
+// ErrQuoteExpired is returned when a reservation names a quote past its expiry.
+var ErrQuoteExpired = errors.New("Quote expired")
+
+type QuoteService interface {
+	CreateQuote(clientAddr string, flightID, seats int, fareClass string, validity time.Duration) (models.Quote, error)
+	GetQuote(quoteID string) (models.Quote, error)
+}
+
+type QuoteServiceImpl struct {
+	DB      *gorm.DB
+	Pricing pricing.Engine
+	Secret  []byte // Signs quote IDs
+}
+
+// CreateQuote prices seats at the current fare and guarantees that price
+// until the quote expires.
+func (q *QuoteServiceImpl) CreateQuote(clientAddr string, flightID, seats int, fareClass string, validity time.Duration) (models.Quote, error) {
+	if seats <= 0 {
+		return models.Quote{}, errors.New("Invalid number of seats")
+	}
+	if validity <= 0 {
+		validity = DefaultQuoteValidity
+	}
+	if validity > MaxQuoteValidity {
+		validity = MaxQuoteValidity
+	}
+
+	var stored models.Flight
+	if err := q.DB.Preload("FareClasses").First(&stored, flightID).Error; err != nil {
+		return models.Quote{}, errors.New("Flight not found")
+	}
+	index, err := newAirportIndex(q.DB)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	now := time.Now()
+	flight := priceFlight(q.Pricing, index, stored, now)
+	left, err := seatsLeft(flight, fareClass)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	if left < seats {
+		return models.Quote{}, errors.New("Insufficient seats available")
+	}
+	unitPrice, _, err := flight.Fare(fareClass)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return models.Quote{}, err
+	}
+	quote := models.Quote{
+		ClientAddr: clientAddr,
+		FlightID:   flightID,
+		FareClass:  fareClass,
+		Seats:      seats,
+		UnitPrice:  unitPrice,
+		TotalPrice: unitPrice.Times(seats),
+		// Whole seconds so the signature survives the database round trip
+		ExpiresAt: now.Add(validity).Truncate(time.Second),
+	}
+	quote.ID = hex.EncodeToString(nonce) + "." + q.sign(hex.EncodeToString(nonce), quote)
+	if err := q.DB.Create(&quote).Error; err != nil {
+		return models.Quote{}, err
+	}
+	return quote, nil
+}
+
+// GetQuote returns a stored quote by ID.
+func (q *QuoteServiceImpl) GetQuote(quoteID string) (models.Quote, error) {
+	var quote models.Quote
+	if err := q.DB.First(&quote, "id = ?", quoteID).Error; err != nil {
+		return models.Quote{}, errors.New("Quote not found")
+	}
+	return quote, nil
+}
+
+// redeem checks a quote against a reservation and marks it used, inside the
+// caller's transaction, returning the guaranteed unit price.
+func (q *QuoteServiceImpl) redeem(tx *gorm.DB, quoteID, clientAddr string, flightID, seats int, fareClass string) (models.Money, error) {
+	var quote models.Quote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&quote, "id = ?", quoteID).Error; err != nil {
+		return 0, errors.New("Quote not found")
+	}
+	nonce, signature, _ := strings.Cut(quote.ID, ".")
+	if !hmac.Equal([]byte(signature), []byte(q.sign(nonce, quote))) {
+		return 0, errors.New("Invalid quote")
+	}
+	if quote.ClientAddr != clientAddr || quote.FlightID != flightID || quote.FareClass != fareClass || quote.Seats != seats {
+		return 0, errors.New("Quote does not match the reservation")
+	}
+	if quote.Used {
+		return 0, errors.New("Quote already used")
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return 0, ErrQuoteExpired
+	}
+	if err := tx.Model(&quote).UpdateColumn("used", true).Error; err != nil {
+		return 0, err
+	}
+	return quote.UnitPrice, nil
+}
+
+func (q *QuoteServiceImpl) sign(nonce string, quote models.Quote) string {
+	mac := hmac.New(sha256.New, q.Secret)
+	fmt.Fprintf(mac, "%s|%s|%d|%s|%d|%d|%d", nonce, quote.ClientAddr, quote.FlightID, quote.FareClass,
+		quote.Seats, quote.UnitPrice, quote.ExpiresAt.Unix())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unitPrice returns the guaranteed price of a quote when one is given, or
+// the current fare from an already priced flight. A nil service accepts no quotes.
+func (q *QuoteServiceImpl) unitPrice(tx *gorm.DB, quoteID, clientAddr string, flight models.Flight, seats int, fareClass string) (models.Money, error) {
+	if quoteID == "" {
+		price, _, err := flight.Fare(fareClass)
+		return price, err
+	}
+	if q == nil {
+		return 0, errors.New("Quotes are not supported")
+	}
+	return q.redeem(tx, quoteID, clientAddr, flight.ID, seats, fareClass)
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+)
+
+type SeatService interface {
+	GenerateSeatMap(flightID int, layout models.SeatLayout) error
+	SeatMap(flightID, fromRow, toRow int) ([]models.Seat, error)
+	ReserveSeatNumbers(clientAddr string, flightID int, numbers []string) ([]models.Booking, models.Flight, []models.Seat, error)
+}
+
+type SeatServiceImpl struct {
+	DB      *gorm.DB
+	Pricing pricing.Engine
+}
+
+// GenerateSeatMap creates the seats of a flight from an aircraft layout, and
+// assigns seats to the bookings and active holds already on the flight.
+func (s *SeatServiceImpl) GenerateSeatMap(flightID int, layout models.SeatLayout) error {
+	seats := layout.Seats(flightID)
+	if len(seats) == 0 {
+		return errors.New("Seat layout has no seats")
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		flight, err := lockFlight(tx, flightID)
+		if err != nil {
+			return err
+		}
+		for _, seat := range seats {
+			if _, err := seatsLeft(flight, seat.Cabin); err != nil {
+				return errors.New("Seat layout cabins do not match the flight's fare classes")
+			}
+		}
+		var existing int64
+		if err := tx.Model(&models.Seat{}).Where("flight_id = ?", flightID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errors.New("Flight already has a seat map")
+		}
+		if err := tx.Create(&seats).Error; err != nil {
+			return err
+		}
+
+		var bookings []models.Booking
+		if err := tx.Where("flight_id = ?", flightID).Order("id").Find(&bookings).Error; err != nil {
+			return err
+		}
+		var holds []models.SeatHold
+		if err := tx.Where("flight_id = ? AND status = ?", flightID, models.HoldActive).Order("created_at").Find(&holds).Error; err != nil {
+			return err
+		}
+		for _, booking := range bookings {
+			if err := assignSeats(tx, flightID, booking.ClientAddr, booking.FareClass, booking.Seats); err != nil {
+				return err
+			}
+		}
+		for _, hold := range holds {
+			if err := assignSeats(tx, flightID, hold.ClientAddr, hold.FareClass, hold.Seats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SeatMap returns the seats of a flight between two rows, inclusive. A zero
+// toRow means the last row.
+func (s *SeatServiceImpl) SeatMap(flightID, fromRow, toRow int) ([]models.Seat, error) {
+	query := s.DB.Where("flight_id = ? AND seat_row >= ?", flightID, fromRow)
+	if toRow > 0 {
+		query = query.Where("seat_row <= ?", toRow)
+	}
+	var seats []models.Seat
+	if err := query.Order("seat_row, letter").Find(&seats).Error; err != nil {
+		return nil, err
+	}
+	if len(seats) == 0 {
+		return nil, errors.New("No seat map for this flight")
+	}
+	return seats, nil
+}
+
+// ReserveSeatNumbers books specific seats, with a booking per cabin. Either
+// every requested seat is taken or none is; a seat picked by someone else
+// first fails the request.
+func (s *SeatServiceImpl) ReserveSeatNumbers(clientAddr string, flightID int, numbers []string) ([]models.Booking, models.Flight, []models.Seat, error) {
+	numbers = normalizeSeatNumbers(numbers)
+	if len(numbers) == 0 {
+		return nil, models.Flight{}, nil, errors.New("No seats requested")
+	}
+
+	var bookings []models.Booking
+	var flight models.Flight
+	var seats []models.Seat
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockFlight(tx, flightID)
+		if err != nil {
+			return err
+		}
+		index, err := newAirportIndex(tx)
+		if err != nil {
+			return err
+		}
+		flight = priceFlight(s.Pricing, index, locked, time.Now())
+		if err := tx.Where("flight_id = ? AND number IN ?", flightID, numbers).Find(&seats).Error; err != nil {
+			return err
+		}
+		if len(seats) != len(numbers) {
+			return errors.New("Unknown seat number")
+		}
+
+		var taken []string
+		perCabin := map[string]int{}
+		for _, seat := range seats {
+			if seat.Occupied {
+				taken = append(taken, seat.Number)
+			}
+			perCabin[seat.Cabin]++
+		}
+		if len(taken) > 0 {
+			return errors.New("Seat already taken: " + strings.Join(taken, ","))
+		}
+
+		// Only claim seats that are still free, so a concurrent pick of the
+		// same seat cannot succeed twice
+		result := tx.Model(&models.Seat{}).
+			Where("flight_id = ? AND number IN ? AND occupied = ?", flightID, numbers, false).
+			Updates(map[string]interface{}{"occupied": true, "client_addr": clientAddr})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(numbers)) {
+			return errors.New("Seat already taken")
+		}
+
+		for cabin, count := range perCabin {
+			unitPrice, _, err := flight.Fare(cabin)
+			if err != nil {
+				return err
+			}
+			if err := takeSeats(tx, &flight, cabin, count); err != nil {
+				return err
+			}
+			booking, err := recordBooking(tx, clientAddr, flightID, cabin, count, unitPrice)
+			if err != nil {
+				return err
+			}
+			bookings = append(bookings, booking)
+			if err := record(tx, events.BookingCreation(booking)); err != nil {
+				return err
+			}
+		}
+		for i := range seats {
+			seats[i].Occupied = true
+			seats[i].ClientAddr = clientAddr
+		}
+		return record(tx, events.FlightChange(flightID, models.ChangeBooking))
+	})
+	if err != nil {
+		return nil, models.Flight{}, nil, err
+	}
+	return bookings, flight, seats, nil
+}
+
+func normalizeSeatNumbers(numbers []string) []string {
+	seen := map[string]bool{}
+	var normalized []string
+	for _, number := range numbers {
+		number = strings.ToUpper(strings.TrimSpace(number))
+		if number == "" || seen[number] {
+			continue
+		}
+		seen[number] = true
+		normalized = append(normalized, number)
+	}
+	return normalized
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB connects to the scratch MySQL database named by AIRLINE_TEST_DSN,
+// e.g. "root:password@tcp(127.0.0.1:3306)/airline_test?parseTime=True&loc=Local",
+// and recreates every table in it. Tests that need a database are skipped
+// when the variable is not set.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("AIRLINE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("AIRLINE_TEST_DSN not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connecting to test database: %v", err)
+	}
+	tables := []interface{}{&models.Flight{}, &models.FareClass{}, &models.Booking{}, &models.Quote{}, &models.Airport{}, &models.Seat{}, &models.SeatHold{}, &models.WaitlistEntry{}, &models.ClientPoints{}, &models.PointsEntry{}, &models.PointsLot{}, &models.Transfer{}, &models.MonitorRegistration{}, &models.OutboxEvent{}, &models.WebhookEndpoint{}, &models.WebhookDelivery{}}
+	if err := db.Migrator().DropTable(tables...); err != nil {
+		t.Fatalf("dropping test tables: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("migrating test database: %v", err)
+	}
+	return db
+}
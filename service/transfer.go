@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+)
+
+// Transfer moves points from sender to recipient, recording a ledger entry
+// on both sides. The recipient must already have a points record, and gets
+// the points with the expiry dates they had for the sender. Replaying an
+// idempotency key returns the original transfer without moving points again.
+func (p *PointsServiceImpl) Transfer(sender, recipient string, amount models.Money, idempotencyKey string) (models.Transfer, models.ClientPoints, error) {
+	if idempotencyKey == "" {
+		return models.Transfer{}, models.ClientPoints{}, errors.New("Idempotency key is required")
+	}
+	if recipient == "" || recipient == sender {
+		return models.Transfer{}, models.ClientPoints{}, errors.New("Invalid recipient")
+	}
+	if amount <= 0 {
+		return models.Transfer{}, models.ClientPoints{}, errors.New("Invalid number of points")
+	}
+
+	var transfer models.Transfer
+	var balance models.ClientPoints
+	err := p.DB.Transaction(func(tx *gorm.DB) error {
+		var known int64
+		if err := tx.Model(&models.ClientPoints{}).Where("client_addr = ?", recipient).Count(&known).Error; err != nil {
+			return err
+		}
+		if known == 0 {
+			return errors.New("Unknown recipient")
+		}
+
+		// Lock both balances in a fixed order so opposing transfers cannot deadlock
+		first, second := sender, recipient
+		if second < first {
+			first, second = second, first
+		}
+		locked := make(map[string]models.ClientPoints, 2)
+		for _, clientAddr := range []string{first, second} {
+			clientPoints, err := p.lockPoints(tx, clientAddr)
+			if err != nil {
+				return err
+			}
+			locked[clientAddr] = clientPoints
+		}
+
+		var existing []models.Transfer
+		if err := tx.Where("sender = ? AND idempotency_key = ?", sender, idempotencyKey).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			if existing[0].Recipient != recipient || existing[0].Amount != amount {
+				return errors.New("Idempotency key already used for another transfer")
+			}
+			transfer, balance = existing[0], locked[sender]
+			return nil
+		}
+
+		now := p.now()
+		if p.TransferLimit > 0 {
+			var sent models.Money
+			err := tx.Model(&models.Transfer{}).
+				Where("sender = ? AND created_at >= ?", sender, startOfDay(now)).
+				Select("COALESCE(SUM(amount), 0)").Scan(&sent).Error
+			if err != nil {
+				return err
+			}
+			if sent+amount > p.TransferLimit {
+				return errors.New("Daily transfer limit exceeded")
+			}
+		}
+
+		var err error
+		balance, _, err = p.postEntry(tx, locked[sender], models.PointsTransfer, -amount, nil, "Transfer to "+recipient)
+		if err != nil {
+			return err
+		}
+		moved, err := consumeLots(tx, sender, amount)
+		if err != nil {
+			return err
+		}
+		_, credit, err := p.postEntry(tx, locked[recipient], models.PointsTransfer, amount, nil, "Transfer from "+sender)
+		if err != nil {
+			return err
+		}
+		if err := carryLots(tx, recipient, credit.ID, moved); err != nil {
+			return err
+		}
+		transfer = models.Transfer{
+			IdempotencyKey: idempotencyKey,
+			Sender:         sender,
+			Recipient:      recipient,
+			Amount:         amount,
+			CreatedAt:      now,
+		}
+		return tx.Create(&transfer).Error
+	})
+	if err != nil {
+		return models.Transfer{}, models.ClientPoints{}, err
+	}
+	return transfer, balance, nil
+}
+
+// carryLots credits a recipient with lots matching those taken from the
+// sender, so transferred points keep the dates they were earned and expire.
+func carryLots(tx *gorm.DB, recipient string, entryID uint, moved []models.PointsLot) error {
+	for _, from := range moved {
+		lot := models.PointsLot{
+			ClientAddr: recipient,
+			EntryID:    entryID,
+			Amount:     from.Amount,
+			Remaining:  from.Amount,
+			EarnedAt:   from.EarnedAt,
+			ExpiresAt:  from.ExpiresAt,
+		}
+		if err := tx.Create(&lot).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
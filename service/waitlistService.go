@@ -0,0 +1,222 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"github.com/Guesstrain/airline/pricing"
+	"gorm.io/gorm"
+)
+
+type WaitlistPolicy string
+
+const (
+	WaitlistFIFO     WaitlistPolicy = "fifo"
+	WaitlistPriority WaitlistPolicy = "priority" // Higher loyalty tiers first
+
+	DefaultOfferDuration = 15 * time.Minute
+)
+
+// WaitlistOffer is a waitlist entry that has been given seats, and the hold
+// that now carries them.
+type WaitlistOffer struct {
+	Entry models.WaitlistEntry
+	Hold  models.SeatHold
+}
+
+type WaitlistService interface {
+	Join(clientAddr string, flightID, seats int, fareClass string) (models.WaitlistEntry, int, error)
+	Leave(clientAddr string, flightID int) (models.WaitlistEntry, error)
+	Allocate(flightID int) ([]WaitlistOffer, error)
+	AllocatePending() ([]WaitlistOffer, error)
+}
+
+type WaitlistServiceImpl struct {
+	DB            *gorm.DB
+	Policy        WaitlistPolicy
+	OfferDuration time.Duration
+	Pricing       pricing.Engine
+	Loyalty       *models.LoyaltyProgram
+}
+
+// ParseWaitlistPolicy returns the named policy, or an error for an unknown name.
+func ParseWaitlistPolicy(name string) (WaitlistPolicy, error) {
+	switch policy := WaitlistPolicy(name); policy {
+	case WaitlistFIFO, WaitlistPriority:
+		return policy, nil
+	}
+	return "", errors.New("Unknown waitlist policy: " + name)
+}
+
+// Join queues a request for seats on a flight that cannot currently satisfy
+// it, and returns the entry with its position in the queue. Under the
+// priority policy the entry ranks by the client's loyalty tier.
+func (w *WaitlistServiceImpl) Join(clientAddr string, flightID, seats int, fareClass string) (models.WaitlistEntry, int, error) {
+	if seats <= 0 {
+		return models.WaitlistEntry{}, 0, errors.New("Invalid number of seats")
+	}
+	var flight models.Flight
+	if err := w.DB.Preload("FareClasses").First(&flight, flightID).Error; err != nil {
+		return models.WaitlistEntry{}, 0, errors.New("Flight not found")
+	}
+	left, err := seatsLeft(flight, fareClass)
+	if err != nil {
+		return models.WaitlistEntry{}, 0, err
+	}
+	if left >= seats {
+		return models.WaitlistEntry{}, 0, errors.New("Seats are available, reserve them directly")
+	}
+	var existing int64
+	err = w.DB.Model(&models.WaitlistEntry{}).
+		Where("flight_id = ? AND client_addr = ? AND status = ?", flightID, clientAddr, models.WaitlistWaiting).
+		Count(&existing).Error
+	if err != nil {
+		return models.WaitlistEntry{}, 0, err
+	}
+	if existing > 0 {
+		return models.WaitlistEntry{}, 0, errors.New("Already on the waitlist for this flight")
+	}
+
+	priority := 0
+	if w.Policy == WaitlistPriority {
+		var clientPoints models.ClientPoints
+		if err := w.DB.Where("client_addr = ?", clientAddr).Limit(1).Find(&clientPoints).Error; err != nil {
+			return models.WaitlistEntry{}, 0, err
+		}
+		priority = tierRank(w.Loyalty, clientPoints.Tier)
+	}
+	entry := models.WaitlistEntry{
+		FlightID:   flightID,
+		ClientAddr: clientAddr,
+		Seats:      seats,
+		FareClass:  fareClass,
+		Priority:   priority,
+		Status:     models.WaitlistWaiting,
+	}
+	if err := w.DB.Create(&entry).Error; err != nil {
+		return models.WaitlistEntry{}, 0, err
+	}
+
+	var waiting []models.WaitlistEntry
+	if err := w.queue(w.DB, flightID).Find(&waiting).Error; err != nil {
+		return entry, 0, err
+	}
+	for i, queued := range waiting {
+		if queued.ID == entry.ID {
+			return entry, i + 1, nil
+		}
+	}
+	return entry, len(waiting), nil
+}
+
+// Leave takes a client off a flight's waitlist. Seats already offered stay
+// held until the hold is confirmed or released.
+func (w *WaitlistServiceImpl) Leave(clientAddr string, flightID int) (models.WaitlistEntry, error) {
+	var entry models.WaitlistEntry
+	err := w.DB.Where("flight_id = ? AND client_addr = ? AND status = ?", flightID, clientAddr, models.WaitlistWaiting).
+		First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.WaitlistEntry{}, errors.New("Not on the waitlist for this flight")
+	}
+	if err != nil {
+		return models.WaitlistEntry{}, err
+	}
+	entry.Status = models.WaitlistCancelled
+	if err := w.DB.Save(&entry).Error; err != nil {
+		return models.WaitlistEntry{}, err
+	}
+	return entry, nil
+}
+
+// Allocate offers a flight's available seats to waiting entries in queue
+// order. Each offer holds the seats for OfferDuration so the customer can
+// confirm. Entries asking for more seats than remain are skipped and keep
+// their place.
+func (w *WaitlistServiceImpl) Allocate(flightID int) ([]WaitlistOffer, error) {
+	var offers []WaitlistOffer
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		flight, err := lockFlight(tx, flightID)
+		if err != nil {
+			return err
+		}
+		index, err := newAirportIndex(tx)
+		if err != nil {
+			return err
+		}
+		var waiting []models.WaitlistEntry
+		if err := w.queue(tx, flightID).Find(&waiting).Error; err != nil {
+			return err
+		}
+		for _, entry := range waiting {
+			if flight.SeatAvailability <= 0 {
+				break
+			}
+			if left, err := seatsLeft(flight, entry.FareClass); err != nil || entry.Seats > left {
+				continue
+			}
+			// Price each offer on the load left by the offers before it
+			unitPrice, _, err := priceFlight(w.Pricing, index, flight, time.Now()).Fare(entry.FareClass)
+			if err != nil {
+				return err
+			}
+			hold, err := placeHold(tx, &flight, entry.ClientAddr, entry.FareClass, entry.Seats, unitPrice, w.offerDuration())
+			if err != nil {
+				return err
+			}
+			entry.Status = models.WaitlistOffered
+			entry.HoldToken = hold.Token
+			if err := tx.Save(&entry).Error; err != nil {
+				return err
+			}
+			offers = append(offers, WaitlistOffer{Entry: entry, Hold: hold})
+		}
+		if len(offers) == 0 {
+			return nil
+		}
+		return record(tx, events.FlightChange(flightID, models.ChangeBooking))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offers, nil
+}
+
+// AllocatePending runs Allocate for every flight that has waiting entries
+// and free seats, whatever released the seats.
+func (w *WaitlistServiceImpl) AllocatePending() ([]WaitlistOffer, error) {
+	var flightIDs []int
+	err := w.DB.Model(&models.WaitlistEntry{}).
+		Joins("JOIN flights ON flights.id = waitlist_entries.flight_id").
+		Where("waitlist_entries.status = ? AND flights.seat_availability > 0", models.WaitlistWaiting).
+		Distinct().Pluck("waitlist_entries.flight_id", &flightIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var offers []WaitlistOffer
+	for _, flightID := range flightIDs {
+		allocated, err := w.Allocate(flightID)
+		if err != nil {
+			return offers, err
+		}
+		offers = append(offers, allocated...)
+	}
+	return offers, nil
+}
+
+func (w *WaitlistServiceImpl) queue(db *gorm.DB, flightID int) *gorm.DB {
+	query := db.Where("flight_id = ? AND status = ?", flightID, models.WaitlistWaiting)
+	if w.Policy == WaitlistPriority {
+		query = query.Order("priority DESC")
+	}
+	return query.Order("created_at, id")
+}
+
+func (w *WaitlistServiceImpl) offerDuration() time.Duration {
+	if w.OfferDuration <= 0 {
+		return DefaultOfferDuration
+	}
+	return w.OfferDuration
+}
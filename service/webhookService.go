@@ -0,0 +1,310 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Guesstrain/airline/clock"
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultWebhookBackoff     = 5 * time.Second
+	MaxWebhookBackoff         = time.Hour
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookTimeout     = 5 * time.Second
+	WebhookDeliveryBatch      = 50
+)
+
+// Headers sent with every webhook delivery. The signature is
+// "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body,
+// keyed with the endpoint's secret.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery" // The event's dedup key
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+type WebhookService interface {
+	Register(endpointURL, eventType string) (models.WebhookEndpoint, error)
+	Enqueue(event events.Event) error
+	DueEndpoints() ([]uint, error)
+	DeliverTo(endpointID uint) (delivered, failed int, err error)
+	Replay(deliveryID uint) (int64, error)
+}
+
+type WebhookServiceImpl struct {
+	DB          *gorm.DB
+	Client      *http.Client  // Nil uses a client with DefaultWebhookTimeout
+	Backoff     time.Duration // Delay before the first retry, doubled after each; zero uses the default
+	MaxAttempts int           // Attempts before a delivery is dead; zero uses the default
+	Clock       clock.Clock   // Nil uses the wall clock
+}
+
+// webhookPayload is the JSON body of a delivery.
+type webhookPayload struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	FlightID   int       `json:"flight_id,omitempty"`
+	BookingID  uint      `json:"booking_id,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	Change     string    `json:"change,omitempty"`
+	Seats      int       `json:"seats,omitempty"`
+	Amount     string    `json:"amount,omitempty"`
+}
+
+// Register adds an endpoint for one event type, or every type with
+// models.WebhookAllEvents, and returns it with its new signing secret.
+func (w *WebhookServiceImpl) Register(endpointURL, eventType string) (models.WebhookEndpoint, error) {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return models.WebhookEndpoint{}, errors.New("Invalid webhook URL")
+	}
+	if !validEventType(eventType) {
+		return models.WebhookEndpoint{}, errors.New("Unknown event type")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	endpoint := models.WebhookEndpoint{
+		URL:       endpointURL,
+		EventType: eventType,
+		Secret:    hex.EncodeToString(secret),
+		Active:    true,
+	}
+	if err := w.DB.Create(&endpoint).Error; err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	return endpoint, nil
+}
+
+// Enqueue queues an event for every active endpoint subscribed to its type.
+// An event already queued for an endpoint, because it was redelivered, is
+// not queued again.
+func (w *WebhookServiceImpl) Enqueue(event events.Event) error {
+	var endpoints []models.WebhookEndpoint
+	err := w.DB.Where("active = ? AND event_type IN ?", true, []string{event.Type, models.WebhookAllEvents}).Find(&endpoints).Error
+	if err != nil || len(endpoints) == 0 {
+		return err
+	}
+	payload := webhookPayload{
+		ID:         event.Key,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		FlightID:   event.FlightID,
+		BookingID:  event.BookingID,
+		ClientAddr: event.ClientAddr,
+		Change:     event.Change,
+		Seats:      event.Seats,
+	}
+	if event.Type != events.FlightChanged {
+		payload.Amount = event.Amount.String()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := w.now()
+	deliveries := make([]models.WebhookDelivery, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			EventKey:      event.Key,
+			EventType:     event.Type,
+			Payload:       string(body),
+			Status:        models.WebhookPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	return w.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+// DueEndpoints returns the endpoints with a pending delivery whose next
+// attempt is due.
+func (w *WebhookServiceImpl) DueEndpoints() ([]uint, error) {
+	var ids []uint
+	err := w.DB.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookPending, w.now()).
+		Distinct().Order("endpoint_id").Pluck("endpoint_id", &ids).Error
+	return ids, err
+}
+
+// DeliverTo sends an endpoint's pending deliveries in the order they were
+// queued. A delivery answered with a 2xx status is done; any other outcome
+// is retried with exponential backoff until MaxAttempts, then it is dead.
+// Sending stops at the first delivery that is not yet due or fails, so an
+// endpoint never receives an event before the ones queued ahead of it.
+func (w *WebhookServiceImpl) DeliverTo(endpointID uint) (delivered, failed int, err error) {
+	var endpoint models.WebhookEndpoint
+	if err := w.DB.First(&endpoint, endpointID).Error; err != nil {
+		return 0, 0, err
+	}
+	var pending []models.WebhookDelivery
+	err = w.DB.Where("endpoint_id = ? AND status = ?", endpointID, models.WebhookPending).
+		Order("id").Limit(WebhookDeliveryBatch).Find(&pending).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, delivery := range pending {
+		if delivery.NextAttemptAt.After(w.now()) {
+			break
+		}
+		code, sendErr := w.send(endpoint, delivery)
+		delivery.Attempts++
+		delivery.ResponseCode = code
+		if sendErr == nil {
+			sent := w.now()
+			delivery.Status = models.WebhookDelivered
+			delivery.DeliveredAt = &sent
+			delivery.LastError = ""
+			delivered++
+		} else {
+			delivery.LastError = truncate(sendErr.Error(), 500)
+			if delivery.Attempts >= w.maxAttempts() {
+				delivery.Status = models.WebhookDead
+			} else {
+				delivery.NextAttemptAt = w.now().Add(w.backoff(delivery.Attempts))
+			}
+			failed++
+		}
+		if err := w.DB.Save(&delivery).Error; err != nil {
+			return delivered, failed, err
+		}
+		if sendErr != nil && delivery.Status == models.WebhookPending {
+			break
+		}
+	}
+	return delivered, failed, nil
+}
+
+// Replay sends a dead or delivered delivery again from its first attempt,
+// or every dead delivery when deliveryID is zero, and returns how many were
+// queued.
+func (w *WebhookServiceImpl) Replay(deliveryID uint) (int64, error) {
+	query := w.DB.Model(&models.WebhookDelivery{})
+	if deliveryID != 0 {
+		query = query.Where("id = ? AND status <> ?", deliveryID, models.WebhookPending)
+	} else {
+		query = query.Where("status = ?", models.WebhookDead)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":          models.WebhookPending,
+		"attempts":        0,
+		"next_attempt_at": w.now(),
+		"delivered_at":    nil,
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if deliveryID != 0 && result.RowsAffected == 0 {
+		return 0, errors.New("Delivery not found or still pending")
+	}
+	return result.RowsAffected, nil
+}
+
+// SignWebhook returns the signature header value for a delivery body.
+// Receivers recompute it with their secret to check a delivery is genuine.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookServiceImpl) send(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, delivery.EventKey)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(endpoint.Secret, timestamp, body))
+
+	response, err := w.client().Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("Endpoint answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+func (w *WebhookServiceImpl) backoff(attempts int) time.Duration {
+	delay := w.Backoff
+	if delay <= 0 {
+		delay = DefaultWebhookBackoff
+	}
+	for i := 1; i < attempts && delay < MaxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxWebhookBackoff {
+		delay = MaxWebhookBackoff
+	}
+	return delay
+}
+
+func (w *WebhookServiceImpl) maxAttempts() int {
+	if w.MaxAttempts <= 0 {
+		return DefaultWebhookMaxAttempts
+	}
+	return w.MaxAttempts
+}
+
+func (w *WebhookServiceImpl) client() *http.Client {
+	if w.Client == nil {
+		return &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	return w.Client
+}
+
+func (w *WebhookServiceImpl) now() time.Time {
+	if w.Clock == nil {
+		return time.Now()
+	}
+	return w.Clock.Now()
+}
+
+func validEventType(eventType string) bool {
+	if eventType == models.WebhookAllEvents {
+		return true
+	}
+	for _, known := range events.Types {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length]
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guesstrain/airline/clock"
+	"github.com/Guesstrain/airline/events"
+	"github.com/Guesstrain/airline/models"
+)
+
+// webhookReceiver is a test endpoint that answers with a set status and
+// remembers the requests it was sent.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), body: body})
+	w.WriteHeader(r.status)
+}
+
+func (r *webhookReceiver) answer(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+func TestWebhookDeliveryRetriesDeadLettersAndReplays(t *testing.T) {
+	db := openTestDB(t)
+	receiver := &webhookReceiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	fixed := clock.NewFixed(start)
+	webhooks := &WebhookServiceImpl{
+		DB:          db,
+		Client:      server.Client(),
+		Backoff:     time.Minute,
+		MaxAttempts: 3,
+		Clock:       fixed,
+	}
+
+	endpoint, err := webhooks.Register(server.URL, events.BookingCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := events.Event{Key: events.BookingCreated + ":1", Type: events.BookingCreated, OccurredAt: start, FlightID: 7, BookingID: 1, Seats: 2}
+	if err := webhooks.Enqueue(event); err != nil {
+		t.Fatal(err)
+	}
+
+	if due, err := webhooks.DueEndpoints(); err != nil || len(due) != 1 || due[0] != endpoint.ID {
+		t.Fatalf("due endpoints = %v, %v; want [%d]", due, err, endpoint.ID)
+	}
+
+	// First attempt fails and is retried after the backoff
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered != 0 || failed != 1 {
+		t.Fatalf("first attempt = %d delivered, %d failed, %v; want 0, 1, nil", delivered, failed, err)
+	}
+	delivery := loadDelivery(t, webhooks)
+	if delivery.Status != models.WebhookPending || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusInternalServerError {
+		t.Fatalf("after first attempt: status %q, attempts %d, code %d", delivery.Status, delivery.Attempts, delivery.ResponseCode)
+	}
+	if want := start.Add(time.Minute); !delivery.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt at %v, want %v", delivery.NextAttemptAt, want)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	header := requests[0].header
+	if header.Get(WebhookEventHeader) != events.BookingCreated || header.Get(WebhookDeliveryHeader) != event.Key {
+		t.Fatalf("event headers = %q, %q", header.Get(WebhookEventHeader), header.Get(WebhookDeliveryHeader))
+	}
+	timestamp := header.Get(WebhookTimestampHeader)
+	if timestamp != strconv.FormatInt(start.Unix(), 10) {
+		t.Fatalf("timestamp header = %q, want %d", timestamp, start.Unix())
+	}
+	if got, want := header.Get(WebhookSignatureHeader), SignWebhook(endpoint.Secret, timestamp, requests[0].body); got != want {
+		t.Fatalf("signature header = %q, want %q", got, want)
+	}
+
+	// Nothing is sent before the backoff has passed
+	if due, err := webhooks.DueEndpoints(); err != nil || len(due) != 0 {
+		t.Fatalf("due endpoints before backoff = %v, %v; want none", due, err)
+	}
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered+failed != 0 {
+		t.Fatalf("before backoff = %d delivered, %d failed, %v; want nothing sent", delivered, failed, err)
+	}
+
+	// The delay doubles after each failure
+	fixed.Advance(time.Minute)
+	if _, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || failed != 1 {
+		t.Fatalf("second attempt = %d failed, %v; want 1, nil", failed, err)
+	}
+	delivery = loadDelivery(t, webhooks)
+	if want := fixed.Now().Add(2 * time.Minute); !delivery.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt at %v, want %v", delivery.NextAttemptAt, want)
+	}
+
+	// The last attempt moves it to the dead letters
+	fixed.Advance(2 * time.Minute)
+	if _, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || failed != 1 {
+		t.Fatalf("third attempt = %d failed, %v; want 1, nil", failed, err)
+	}
+	delivery = loadDelivery(t, webhooks)
+	if delivery.Status != models.WebhookDead || delivery.Attempts != 3 {
+		t.Fatalf("after last attempt: status %q, attempts %d; want dead after 3", delivery.Status, delivery.Attempts)
+	}
+	fixed.Advance(time.Hour)
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered+failed != 0 {
+		t.Fatalf("dead delivery was sent again: %d delivered, %d failed, %v", delivered, failed, err)
+	}
+
+	// Replay queues it from its first attempt and it goes through
+	if queued, err := webhooks.Replay(0); err != nil || queued != 1 {
+		t.Fatalf("replay = %d, %v; want 1, nil", queued, err)
+	}
+	delivery = loadDelivery(t, webhooks)
+	if delivery.Status != models.WebhookPending || delivery.Attempts != 0 {
+		t.Fatalf("after replay: status %q, attempts %d; want pending with 0", delivery.Status, delivery.Attempts)
+	}
+	receiver.answer(http.StatusNoContent)
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered != 1 || failed != 0 {
+		t.Fatalf("replayed attempt = %d delivered, %d failed, %v; want 1, 0, nil", delivered, failed, err)
+	}
+	delivery = loadDelivery(t, webhooks)
+	if delivery.Status != models.WebhookDelivered || delivery.DeliveredAt == nil {
+		t.Fatalf("after replayed attempt: status %q, delivered at %v", delivery.Status, delivery.DeliveredAt)
+	}
+	if len(receiver.received()) != 4 {
+		t.Fatalf("receiver got %d requests, want 4", len(receiver.received()))
+	}
+	if _, err := webhooks.Replay(delivery.ID + 1); err == nil {
+		t.Fatal("replaying an unknown delivery succeeded")
+	}
+}
+
+func TestWebhookDeliveryKeepsEndpointOrder(t *testing.T) {
+	db := openTestDB(t)
+	receiver := &webhookReceiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	fixed := clock.NewFixed(start)
+	webhooks := &WebhookServiceImpl{DB: db, Client: server.Client(), Backoff: time.Minute, Clock: fixed}
+	endpoint, err := webhooks.Register(server.URL, models.WebhookAllEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{events.BookingCreated, events.BookingCancelled}
+	keys := []string{types[0] + ":1", types[1] + ":1"}
+	for i, key := range keys {
+		event := events.Event{Key: key, Type: types[i], OccurredAt: start, BookingID: 1}
+		if err := webhooks.Enqueue(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second event waits behind the first while it is failing
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered != 0 || failed != 1 {
+		t.Fatalf("first round = %d delivered, %d failed, %v; want 0, 1, nil", delivered, failed, err)
+	}
+	if due, err := webhooks.DueEndpoints(); err != nil || len(due) != 1 {
+		t.Fatalf("due endpoints = %v, %v; want the endpoint with its second event", due, err)
+	}
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered+failed != 0 {
+		t.Fatalf("during backoff = %d delivered, %d failed, %v; want nothing sent", delivered, failed, err)
+	}
+
+	receiver.answer(http.StatusOK)
+	fixed.Advance(time.Minute)
+	if delivered, failed, err := webhooks.DeliverTo(endpoint.ID); err != nil || delivered != 2 || failed != 0 {
+		t.Fatalf("after backoff = %d delivered, %d failed, %v; want 2, 0, nil", delivered, failed, err)
+	}
+	var sent []string
+	for _, request := range receiver.received() {
+		sent = append(sent, request.header.Get(WebhookDeliveryHeader))
+	}
+	if want := []string{keys[0], keys[0], keys[1]}; strings.Join(sent, " ") != strings.Join(want, " ") {
+		t.Fatalf("deliveries sent = %v, want %v", sent, want)
+	}
+}
+
+func loadDelivery(t *testing.T, webhooks *WebhookServiceImpl) models.WebhookDelivery {
+	t.Helper()
+	var delivery models.WebhookDelivery
+	if err := webhooks.DB.First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
@@ -3,13 +3,51 @@ package utility
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Guesstrain/airline/models"
 )
 
+// MaxDatagramSize is the largest reply clients read in one datagram.
+const MaxDatagramSize = 1024
+
+// Fit serializes the largest prefix of count items whose reply fits in
+// MaxDatagramSize and returns it with the number of items kept. At least
+// one item is kept, even if it alone does not fit.
+func Fit(count int, serialize func(n int) ([]byte, error)) ([]byte, int, error) {
+	response, err := serialize(count)
+	if err != nil || len(response) <= MaxDatagramSize || count <= 1 {
+		return response, count, err
+	}
+	kept, fitted := 0, []byte(nil)
+	low, high := 1, count-1
+	for low <= high {
+		mid := (low + high) / 2
+		response, err := serialize(mid)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(response) <= MaxDatagramSize {
+			kept, fitted = mid, response
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+	if fitted == nil {
+		response, err := serialize(1)
+		return response, 1, err
+	}
+	return fitted, kept, nil
+}
+
 func DeserializeFlight(data []byte) (int, models.RequestFlight, string, error) {
 	var flight models.RequestFlight
 	buffer := bytes.NewBuffer(data)
@@ -108,12 +146,173 @@ func DeserializeFlight(data []byte) (int, models.RequestFlight, string, error) {
 		return -1, flight, "", err
 	}
 	requestID := string(requestIDBytes)
+
+	// Read optional parameters
+	if err := decodeOptions(buffer, &flight); err != nil {
+		return -1, flight, "", err
+	}
 	fmt.Println("flight:", flight)
 	fmt.Println("requestID:", requestID)
 	return opcodeInt, flight, requestID, nil
 }
 
+// decodeOptions reads the optional key/value parameters that follow the
+// request ID. Older clients do not send them, so a missing section is not an error.
+func decodeOptions(buffer *bytes.Buffer, flight *models.RequestFlight) error {
+	count, err := buffer.ReadByte()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(count); i++ {
+		key, err := decodeString(buffer)
+		if err != nil {
+			return err
+		}
+		value, err := decodeString(buffer)
+		if err != nil {
+			return err
+		}
+		if err := applyOption(flight, key, value); err != nil {
+			return fmt.Errorf("invalid option %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func applyOption(flight *models.RequestFlight, key, value string) error {
+	var err error
+	switch key {
+	case "max_stops":
+		flight.MaxStops, err = strconv.Atoi(value)
+	case "min_layover":
+		flight.MinLayover, err = parseMinutes(value)
+	case "max_layover":
+		flight.MaxLayover, err = parseMinutes(value)
+	case "sort":
+		flight.SortBy = value
+	case "legs":
+		flight.FlightIDs, err = parseIntList(value)
+	case "min_price":
+		flight.MinPrice, err = models.ParseMoney(value)
+	case "max_price":
+		flight.MaxPrice, err = models.ParseMoney(value)
+	case "min_seats":
+		flight.MinSeats, err = strconv.Atoi(value)
+	case "depart_after":
+		flight.DepartAfter = value
+	case "depart_before":
+		flight.DepartBefore = value
+	case "carrier":
+		flight.Carrier = value
+	case "cursor":
+		flight.Cursor = value
+	case "page_size":
+		flight.PageSize, err = strconv.Atoi(value)
+	case "hold_token":
+		flight.HoldToken = value
+	case "fare_class":
+		flight.FareClass = value
+	case "seats":
+		flight.SeatNumbers = strings.Split(value, ",")
+	case "row_from":
+		flight.FromRow, err = strconv.Atoi(value)
+	case "row_to":
+		flight.ToRow, err = strconv.Atoi(value)
+	case "quote_id":
+		flight.QuoteID = value
+	case "recipient":
+		flight.Recipient = value
+	case "points":
+		flight.Points, err = models.ParseMoney(value)
+	case "idempotency_key":
+		flight.IdempotencyKey = value
+	case "notify_end":
+		flight.NotifyEnd = value == "1"
+	case "seq":
+		flight.Sequence, err = strconv.ParseUint(value, 10, 64)
+	case "seats_below":
+		flight.Condition.SeatsBelow, err = strconv.Atoi(value)
+	case "seats_available":
+		flight.Condition.SeatsAvailable = value == "1"
+	case "fare_threshold":
+		flight.Condition.FareThreshold, err = models.ParseMoney(value)
+	case "url":
+		flight.WebhookURL = value
+	case "event_type":
+		flight.EventType = value
+	case "layout_rows":
+		flight.SeatLayout.Rows, err = strconv.Atoi(value)
+	case "layout_letters":
+		flight.SeatLayout.Letters = value
+	case "layout_cabins":
+		flight.SeatLayout.Cabins, err = parseCabinRows(value)
+	case "exit_rows":
+		flight.SeatLayout.ExitRows, err = parseIntList(value)
+	}
+	return err
+}
+
+func parseMinutes(value string) (time.Duration, error) {
+	minutes, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+func parseIntList(value string) ([]int, error) {
+	var ints []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// parseCabinRows parses cabin row ranges such as "J:1-3,Y:4-30".
+func parseCabinRows(value string) ([]models.CabinRows, error) {
+	var cabins []models.CabinRows
+	for _, part := range strings.Split(value, ",") {
+		cabin, rows, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, errors.New("Invalid cabin rows: " + part)
+		}
+		from, to, ok := strings.Cut(rows, "-")
+		if !ok {
+			to = from
+		}
+		fromRow, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, err
+		}
+		toRow, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, err
+		}
+		cabins = append(cabins, models.CabinRows{Cabin: cabin, FromRow: fromRow, ToRow: toRow})
+	}
+	return cabins, nil
+}
+
 func SerializeFlights(flights []models.Flight, opcode, statuscode byte, message string) ([]byte, error) {
+	return SerializeFlightsWithAttributes(flights, opcode, statuscode, message, nil)
+}
+
+// SerializeFlightPage packs one page of flights and its continuation token.
+func SerializeFlightPage(page models.FlightPage, opcode, statuscode byte, message string) ([]byte, error) {
+	return SerializeFlightsWithAttributes(page.Flights, opcode, statuscode, message, pageAttributes(page.NextCursor))
+}
+
+// SerializeFlightsWithAttributes is SerializeFlights followed by a set of
+// key/value attributes after the message. Clients that stop reading at the
+// message are unaffected.
+func SerializeFlightsWithAttributes(flights []models.Flight, opcode, statuscode byte, message string, attrs map[string]string) ([]byte, error) {
 	buffer := new(bytes.Buffer)
 
 	// Pack the opcode and statuscode as single bytes
@@ -142,6 +341,10 @@ func SerializeFlights(flights []models.Flight, opcode, statuscode byte, message
 		return nil, err
 	}
 
+	if err := encodeAttributes(buffer, attrs); err != nil {
+		return nil, err
+	}
+
 	return buffer.Bytes(), nil
 }
 
@@ -169,7 +372,7 @@ func encodeFlight(buffer *bytes.Buffer, flight models.Flight) error {
 	}
 
 	// Encode Airfare as a string
-	airfareStr := fmt.Sprintf("%.2f", flight.Airfare)
+	airfareStr := flight.Airfare.String()
 	if err := encodeString(buffer, airfareStr); err != nil {
 		return err
 	}
@@ -180,10 +383,346 @@ func encodeFlight(buffer *bytes.Buffer, flight models.Flight) error {
 		return err
 	}
 
+	// Encode the fare classes: a count, then code, name, fare, seats,
+	// refundable flag and earn rate for each class
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(flight.FareClasses))); err != nil {
+		return err
+	}
+	for _, class := range flight.FareClasses {
+		refundable := "0"
+		if class.Refundable {
+			refundable = "1"
+		}
+		fields := []string{
+			class.Code,
+			class.Name,
+			class.Fare.String(),
+			fmt.Sprintf("%d", class.SeatAvailability),
+			refundable,
+			fmt.Sprintf("%.2f", class.EarnRate),
+		}
+		for _, field := range fields {
+			if err := encodeString(buffer, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SerializeItineraries packs itineraries the same way SerializeFlights packs
+// flights, with each itinerary written as its legs followed by its totals.
+func SerializeItineraries(itineraries []models.Itinerary, opcode, statuscode byte, message string, attrs map[string]string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(itineraries))); err != nil {
+		return nil, err
+	}
+
+	for _, itinerary := range itineraries {
+		if err := binary.Write(buffer, binary.BigEndian, byte(len(itinerary.Legs))); err != nil {
+			return nil, err
+		}
+		for _, leg := range itinerary.Legs {
+			if err := encodeFlight(buffer, leg); err != nil {
+				return nil, err
+			}
+		}
+		if err := encodeString(buffer, itinerary.TotalFare.String()); err != nil {
+			return nil, err
+		}
+		// Total travel time in minutes
+		if err := encodeString(buffer, fmt.Sprintf("%d", int(itinerary.TotalDuration.Minutes()))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	if err := encodeAttributes(buffer, attrs); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializeItineraryPage packs one page of itineraries and its continuation token.
+func SerializeItineraryPage(page models.ItineraryPage, opcode, statuscode byte, message string) ([]byte, error) {
+	return SerializeItineraries(page.Itineraries, opcode, statuscode, message, pageAttributes(page.NextCursor))
+}
+
+// SerializeAirports packs airport matches: code, name, city, country and time zone.
+func SerializeAirports(airports []models.Airport, opcode, statuscode byte, message string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(airports))); err != nil {
+		return nil, err
+	}
+
+	for _, airport := range airports {
+		for _, field := range []string{airport.Code, airport.Name, airport.City, airport.Country, airport.TimeZone} {
+			if err := encodeString(buffer, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializeSeatMap packs a seat map. Each seat is its number, cabin and a
+// flags byte: bit 0 occupied, bit 1 exit row, bits 2-3 position
+// (0 middle, 1 window, 2 aisle).
+func SerializeSeatMap(seats []models.Seat, opcode, statuscode byte, message string, attrs map[string]string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(seats))); err != nil {
+		return nil, err
+	}
+
+	for _, seat := range seats {
+		if err := encodeString(buffer, seat.Number); err != nil {
+			return nil, err
+		}
+		if err := encodeString(buffer, seat.Cabin); err != nil {
+			return nil, err
+		}
+		var flags byte
+		if seat.Occupied {
+			flags |= 1
+		}
+		if seat.ExitRow {
+			flags |= 1 << 1
+		}
+		switch seat.Position {
+		case models.SeatWindow:
+			flags |= 1 << 2
+		case models.SeatAisle:
+			flags |= 2 << 2
+		}
+		if err := buffer.WriteByte(flags); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	if err := encodeAttributes(buffer, attrs); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializeStatement packs a page of ledger entries, newest first. Each entry
+// is its ID, type, amount, balance after, booking ID (empty when none),
+// timestamp and description. The stored and ledger balances and the
+// continuation token travel as attributes.
+func SerializeStatement(statement models.PointsStatement, opcode, statuscode byte, message string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(statement.Entries))); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range statement.Entries {
+		bookingID := ""
+		if entry.BookingID != nil {
+			bookingID = strconv.FormatUint(uint64(*entry.BookingID), 10)
+		}
+		fields := []string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.Type,
+			entry.Amount.String(),
+			entry.Balance.String(),
+			bookingID,
+			entry.CreatedAt.Format(time.RFC3339),
+			entry.Description,
+		}
+		for _, field := range fields {
+			if err := encodeString(buffer, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	attrs := pageAttributes(statement.NextCursor)
+	if statuscode == 0 {
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs["balance"] = statement.Balance.String()
+		attrs["ledger_balance"] = statement.LedgerBalance.String()
+	}
+	if err := encodeAttributes(buffer, attrs); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializePointsLots packs points lots as their remaining points, earn
+// date and expiry date.
+func SerializePointsLots(lots []models.PointsLot, opcode, statuscode byte, message string, attrs map[string]string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(lots))); err != nil {
+		return nil, err
+	}
+
+	for _, lot := range lots {
+		expiresAt := ""
+		if lot.ExpiresAt != nil {
+			expiresAt = lot.ExpiresAt.Format(time.RFC3339)
+		}
+		for _, field := range []string{lot.Remaining.String(), lot.EarnedAt.Format(time.RFC3339), expiresAt} {
+			if err := encodeString(buffer, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	if err := encodeAttributes(buffer, attrs); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializeMonitors packs flight and route monitor registrations as a
+// subject (a flight ID, or "SRC -> DST" for a route) and expiry.
+func SerializeMonitors(infos []models.ClientInfo, routes []models.RouteMonitor, opcode, statuscode byte, message string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := binary.Write(buffer, binary.BigEndian, statuscode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, opcode); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(infos)+len(routes))); err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if err := encodeString(buffer, strconv.Itoa(info.FlightID)); err != nil {
+			return nil, err
+		}
+		if err := encodeString(buffer, info.Expiry.Format(time.RFC3339)); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range routes {
+		if err := encodeString(buffer, route.Source+" -> "+route.Destination); err != nil {
+			return nil, err
+		}
+		if err := encodeString(buffer, route.Expiry.Format(time.RFC3339)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encodeString(buffer, message); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func pageAttributes(nextCursor string) map[string]string {
+	if nextCursor == "" {
+		return nil
+	}
+	return map[string]string{"next_cursor": nextCursor}
+}
+
+// encodeAttributes writes a count followed by key/value string pairs in key
+// order. Nothing is written for an empty set, so plain replies keep their
+// original layout.
+func encodeAttributes(buffer *bytes.Buffer, attrs map[string]string) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if err := binary.Write(buffer, binary.BigEndian, byte(len(keys))); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := encodeString(buffer, key); err != nil {
+			return err
+		}
+		if err := encodeString(buffer, attrs[key]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// maxStringLength is the longest string a one-byte length prefix can carry.
+const maxStringLength = 255
+
+// encodeString writes a length-prefixed string. Longer strings are cut at
+// the last whole UTF-8 character that fits, so a long message or attribute
+// still produces a reply the client can read.
 func encodeString(buffer *bytes.Buffer, str string) error {
+	if len(str) > maxStringLength {
+		cut := maxStringLength
+		for cut > 0 && !utf8.RuneStart(str[cut]) {
+			cut--
+		}
+		str = str[:cut]
+	}
 	length := byte(len(str))
 	if err := binary.Write(buffer, binary.BigEndian, length); err != nil {
 		return err
@@ -193,3 +732,15 @@ func encodeString(buffer *bytes.Buffer, str string) error {
 	}
 	return nil
 }
+
+func decodeString(buffer *bytes.Buffer) (string, error) {
+	length, err := buffer.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	str := make([]byte, length)
+	if _, err := io.ReadFull(buffer, str); err != nil {
+		return "", err
+	}
+	return string(str), nil
+}
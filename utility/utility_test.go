@@ -0,0 +1,117 @@
+package utility
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Guesstrain/airline/models"
+)
+
+func TestFitKeepsLargestPrefix(t *testing.T) {
+	serialize := func(n int) ([]byte, error) {
+		return make([]byte, n*300), nil
+	}
+	response, kept, err := Fit(10, serialize)
+	if err != nil || kept != 3 || len(response) != 900 {
+		t.Fatalf("Fit(10) = %d bytes, %d kept, %v; want 900 bytes, 3 kept", len(response), kept, err)
+	}
+	response, kept, err = Fit(3, serialize)
+	if err != nil || kept != 3 || len(response) != 900 {
+		t.Fatalf("Fit(3) = %d bytes, %d kept, %v; want everything", len(response), kept, err)
+	}
+	response, kept, err = Fit(0, serialize)
+	if err != nil || kept != 0 || len(response) != 0 {
+		t.Fatalf("Fit(0) = %d bytes, %d kept, %v; want an empty reply", len(response), kept, err)
+	}
+}
+
+func TestFitKeepsOneOversizedItem(t *testing.T) {
+	response, kept, err := Fit(4, func(n int) ([]byte, error) {
+		return make([]byte, n*2000), nil
+	})
+	if err != nil || kept != 1 || len(response) != 2000 {
+		t.Fatalf("Fit = %d bytes, %d kept, %v; want the first item alone", len(response), kept, err)
+	}
+}
+
+func encodeOptions(t *testing.T, pairs ...string) *bytes.Buffer {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte(byte(len(pairs) / 2))
+	for _, s := range pairs {
+		if err := encodeString(buffer, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buffer
+}
+
+func TestDecodeOptions(t *testing.T) {
+	var flight models.RequestFlight
+	buffer := encodeOptions(t,
+		"max_stops", "2",
+		"min_layover", "45",
+		"min_price", "12.50",
+		"legs", "4,7",
+		"seats", "12A,12B",
+		"notify_end", "1",
+		"layout_cabins", "J:1-3,Y:4-30",
+		"unknown", "ignored",
+	)
+	if err := decodeOptions(buffer, &flight); err != nil {
+		t.Fatal(err)
+	}
+	if flight.MaxStops != 2 || flight.MinLayover != 45*time.Minute || flight.MinPrice != 1250 {
+		t.Errorf("stops %d, layover %v, price %v", flight.MaxStops, flight.MinLayover, flight.MinPrice)
+	}
+	if len(flight.FlightIDs) != 2 || flight.FlightIDs[0] != 4 || flight.FlightIDs[1] != 7 {
+		t.Errorf("legs = %v, want [4 7]", flight.FlightIDs)
+	}
+	if strings.Join(flight.SeatNumbers, " ") != "12A 12B" || !flight.NotifyEnd {
+		t.Errorf("seats %v, notify end %v", flight.SeatNumbers, flight.NotifyEnd)
+	}
+	if len(flight.SeatLayout.Cabins) != 2 {
+		t.Errorf("cabins = %+v, want two", flight.SeatLayout.Cabins)
+	}
+}
+
+func TestDecodeOptionsWithoutSection(t *testing.T) {
+	var flight models.RequestFlight
+	if err := decodeOptions(new(bytes.Buffer), &flight); err != nil {
+		t.Fatalf("older request without options: %v", err)
+	}
+}
+
+func TestDecodeOptionsRejectsBadValues(t *testing.T) {
+	for _, pair := range [][2]string{{"max_stops", "two"}, {"min_price", "1.234"}, {"legs", "4,x"}} {
+		var flight models.RequestFlight
+		if err := decodeOptions(encodeOptions(t, pair[0], pair[1]), &flight); err == nil {
+			t.Errorf("option %s=%q accepted", pair[0], pair[1])
+		}
+	}
+	// A count promising more options than were sent
+	buffer := encodeOptions(t, "sort", "price")
+	buffer.Bytes()[0] = 2
+	var flight models.RequestFlight
+	if err := decodeOptions(buffer, &flight); err == nil {
+		t.Error("truncated options accepted")
+	}
+}
+
+func TestEncodeStringTruncates(t *testing.T) {
+	long := strings.Repeat("a", 254) + "é" // The last character straddles the limit
+	buffer := new(bytes.Buffer)
+	if err := encodeString(buffer, long); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeString(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != strings.Repeat("a", 254) || !utf8.ValidString(decoded) {
+		t.Fatalf("decoded %d bytes, want the 254 whole characters that fit", len(decoded))
+	}
+}